@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+// Which version of a song is kept when the same song shows up several times
+const (
+	DedupOff = "off"
+	// DedupEarliest keeps the version from the earliest release, usually the single
+	DedupEarliest = "earliest"
+	// DedupAlbum keeps the version from an album over singles and compilations
+	DedupAlbum = "album"
+)
+
+var (
+	// featuringPattern matches the "(feat. X)" or "- with X" parts of a title,
+	// which often differ between the single and the album version
+	featuringPattern = regexp.MustCompile(`\s*[(\[](feat\.?|ft\.?|featuring|with) [^)\]]*[)\]]|\s+-\s+(feat\.?|ft\.?|featuring|with) .*$`)
+	// punctuationPattern matches everything that is not a letter or a digit
+	punctuationPattern = regexp.MustCompile(`[^\pL\pN]+`)
+)
+
+// DedupResult : What to do with the tracks to add so every song is in the playlist only once
+type DedupResult struct {
+	// Add are the tracks to add, in their original order
+	Add []*models.Track
+	// Remove are the tracks already in the playlist replaced by a better version
+	Remove []*models.Track
+	// Skipped are the tracks not added because another version of the song is kept
+	Skipped []*models.Track
+	// KeptOver maps the skipped and removed tracks to the version kept instead
+	KeptOver map[*models.Track]*models.Track
+}
+
+// DedupTracks : Removes the songs added several times, for example once from a single and once from
+// the album that followed. Candidates are also compared to the tracks already in the playlist.
+// Two tracks are the same song when they share their ISRC, looked up with the full track details,
+// or when the ISRC is unknown, the same normalized title and set of artists.
+// Candidates must have their Album set, keep is DedupEarliest or DedupAlbum.
+func DedupTracks(c *models.Client, existing, candidates []*models.Track, keep string) (*DedupResult, error) {
+	if keep != DedupEarliest && keep != DedupAlbum {
+		return nil, fmt.Errorf("invalid dedup mode %q, expected %q or %q", keep, DedupEarliest, DedupAlbum)
+	}
+	if err := lookupISRCs(c, candidates); err != nil {
+		return nil, err
+	}
+
+	type version struct {
+		track    *models.Track
+		existing bool
+	}
+	// The versions of each song, in order of appearance
+	songs := map[string][]version{}
+	var keys []string
+	add := func(t *models.Track, existing bool) {
+		key := songKey(t)
+		if _, ok := songs[key]; !ok {
+			keys = append(keys, key)
+		}
+		songs[key] = append(songs[key], version{t, existing})
+	}
+	for _, t := range existing {
+		add(t, true)
+	}
+	for _, t := range candidates {
+		add(t, false)
+	}
+
+	kept := map[*models.Track]bool{}
+	res := &DedupResult{KeptOver: map[*models.Track]*models.Track{}}
+	for _, key := range keys {
+		versions := songs[key]
+		best := versions[0]
+		for _, v := range versions[1:] {
+			if better(v.track, best.track, keep) {
+				best = v
+			}
+		}
+		kept[best.track] = true
+		for _, v := range versions {
+			switch {
+			case v.track == best.track:
+				continue
+			case v.existing && !best.existing:
+				res.Remove = append(res.Remove, v.track)
+			case !v.existing:
+				res.Skipped = append(res.Skipped, v.track)
+			}
+			res.KeptOver[v.track] = best.track
+		}
+	}
+	for _, t := range candidates {
+		if kept[t] {
+			res.Add = append(res.Add, t)
+		}
+	}
+	return res, nil
+}
+
+// lookupISRCs fills the external IDs of the tracks that don't have them
+func lookupISRCs(c *models.Client, tracks []*models.Track) error {
+	var ids []string
+	byID := map[string][]*models.Track{}
+	for _, t := range tracks {
+		if t.ExternalIDs == nil && t.ID != "" {
+			if _, ok := byID[t.ID]; !ok {
+				ids = append(ids, t.ID)
+			}
+			byID[t.ID] = append(byID[t.ID], t)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	full, err := c.GetTracks(ids)
+	if err != nil {
+		return err
+	}
+	for _, f := range full {
+		// Unknown IDs come back as null
+		if f == nil {
+			continue
+		}
+		for _, t := range byID[f.ID] {
+			t.ExternalIDs = f.ExternalIDs
+		}
+	}
+	return nil
+}
+
+// songKey returns the identity of the song recorded by the track
+func songKey(t *models.Track) string {
+	if isrc := t.ExternalIDs["isrc"]; isrc != "" {
+		return "isrc:" + strings.ToUpper(isrc)
+	}
+	artists := make([]string, 0, len(t.Artists))
+	for _, a := range t.Artists {
+		artists = append(artists, normalize(a.Name))
+	}
+	sort.Strings(artists)
+	return "title:" + normalize(featuringPattern.ReplaceAllString(t.Name, "")) + "|" + strings.Join(artists, ",")
+}
+
+// normalize lowercases s and keeps only its letters and digits separated by single spaces
+func normalize(s string) string {
+	return strings.TrimSpace(punctuationPattern.ReplaceAllString(strings.ToLower(s), " "))
+}
+
+// better reports whether track a should be kept over track b
+func better(a, b *models.Track, keep string) bool {
+	if a.Album == nil || b.Album == nil {
+		return a.Album != nil
+	}
+	if keep == DedupAlbum {
+		aIsAlbum, bIsAlbum := a.Album.AlbumType == "album", b.Album.AlbumType == "album"
+		if aIsAlbum != bIsAlbum {
+			return aIsAlbum
+		}
+	}
+	// Invalid dates parse to the zero time, they should not win
+	if !a.Album.ReleaseDate.Valid() || !b.Album.ReleaseDate.Valid() {
+		return a.Album.ReleaseDate.Valid() && !b.Album.ReleaseDate.Valid()
+	}
+	return a.Album.ReleaseDate.Time.Before(b.Album.ReleaseDate.Time)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+func TestDedupTracks(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	now := time.Now()
+	band := s.FollowArtist(models.Artist{Name: "Band"})
+	other := s.FollowArtist(models.Artist{Name: "Other Band"})
+
+	// The single and the album version share their ISRC but not their title
+	single := s.AddAlbum(band, models.SimplifiedAlbumObject{Name: "Song", AlbumType: "single", ReleaseDate: releaseDay(now.AddDate(0, 0, -30))},
+		models.Track{ID: "single-song", Name: "Song (Radio Edit)", ExternalIDs: map[string]string{"isrc": "USAAA2000001"}})
+	album := s.AddAlbum(band, models.SimplifiedAlbumObject{Name: "LP", AlbumType: "album", ReleaseDate: releaseDay(now.AddDate(0, 0, -7))},
+		models.Track{ID: "album-song", Name: "Song", ExternalIDs: map[string]string{"isrc": "usaaa2000001"}},
+		models.Track{ID: "album-other", Name: "Other Song", ExternalIDs: map[string]string{"isrc": "USAAA2000002"}})
+	cover := s.AddAlbum(other, models.SimplifiedAlbumObject{Name: "Covers", AlbumType: "album", ReleaseDate: releaseDay(now.AddDate(0, 0, -2))})
+
+	// The tracks given to DedupTracks, the ones without an ID are unknown to the server
+	// and only matched by their title and artists
+	catalog := map[string]*models.Track{
+		"single-song":  {ID: "single-song", Name: "Song (Radio Edit)", Album: single},
+		"album-song":   {ID: "album-song", Name: "Song", Album: album},
+		"album-other":  {ID: "album-other", Name: "Other Song", Album: album},
+		"feat-single":  {Name: "Duet (feat. Guest)", Album: single},
+		"feat-album":   {Name: "Duet - with Guest", Album: album},
+		"title-other":  {Name: "Other Song", Album: album},
+		"cover-song":   {Name: "Other Song", Album: cover},
+		"undated-song": {Name: "Duet", Album: &models.SimplifiedAlbumObject{Name: "Undated", AlbumType: "single", Artists: single.Artists}},
+	}
+	isrcs := map[string]string{"single-song": "USAAA2000001", "album-song": "USAAA2000001", "album-other": "USAAA2000002"}
+	// from maps the copies given to DedupTracks to their catalog name
+	from := map[*models.Track]string{}
+	// tracks returns fresh copies of the catalog tracks, with their ISRC for the tracks already in the playlist
+	tracks := func(existing bool, names ...string) []*models.Track {
+		var ts []*models.Track
+		for _, name := range names {
+			tr := *catalog[name]
+			tr.Artists = tr.Album.Artists
+			if existing && isrcs[name] != "" {
+				tr.ExternalIDs = map[string]string{"isrc": isrcs[name]}
+			}
+			from[&tr] = name
+			ts = append(ts, &tr)
+		}
+		return ts
+	}
+	// names returns the catalog names of the tracks
+	names := func(ts []*models.Track) []string {
+		var ns []string
+		for _, tr := range ts {
+			ns = append(ns, from[tr])
+		}
+		return ns
+	}
+
+	tests := []struct {
+		name        string
+		keep        string
+		existing    []string
+		candidates  []string
+		wantAdd     []string
+		wantRemove  []string
+		wantSkipped []string
+		wantErr     bool
+	}{
+		{
+			name:        "album version kept over the single",
+			keep:        DedupAlbum,
+			candidates:  []string{"single-song", "album-song", "album-other"},
+			wantAdd:     []string{"album-song", "album-other"},
+			wantSkipped: []string{"single-song"},
+		},
+		{
+			name:        "earliest version kept",
+			keep:        DedupEarliest,
+			candidates:  []string{"album-song", "single-song", "album-other"},
+			wantAdd:     []string{"single-song", "album-other"},
+			wantSkipped: []string{"album-song"},
+		},
+		{
+			name:       "playlist single replaced by the album version",
+			keep:       DedupAlbum,
+			existing:   []string{"single-song"},
+			candidates: []string{"album-song"},
+			wantAdd:    []string{"album-song"},
+			wantRemove: []string{"single-song"},
+		},
+		{
+			name:        "playlist version kept",
+			keep:        DedupEarliest,
+			existing:    []string{"single-song"},
+			candidates:  []string{"album-song"},
+			wantSkipped: []string{"album-song"},
+		},
+		{
+			name:        "same title without the featured artist",
+			keep:        DedupAlbum,
+			candidates:  []string{"feat-single", "feat-album"},
+			wantAdd:     []string{"feat-album"},
+			wantSkipped: []string{"feat-single"},
+		},
+		{
+			name:       "same title by other artists",
+			keep:       DedupAlbum,
+			candidates: []string{"title-other", "cover-song"},
+			wantAdd:    []string{"title-other", "cover-song"},
+		},
+		{
+			name:        "dated release kept over an undated one",
+			keep:        DedupEarliest,
+			candidates:  []string{"undated-song", "feat-single"},
+			wantAdd:     []string{"feat-single"},
+			wantSkipped: []string{"undated-song"},
+		},
+		{
+			name:       "invalid mode",
+			keep:       DedupOff,
+			candidates: []string{"album-song"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		res, err := DedupTracks(s.Client(), tracks(true, tt.existing...), tracks(false, tt.candidates...), tt.keep)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: DedupTracks succeeded", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := names(res.Add); !equalStrings(got, tt.wantAdd) {
+			t.Errorf("%s: Add = %v, want %v", tt.name, got, tt.wantAdd)
+		}
+		if got := names(res.Remove); !equalStrings(got, tt.wantRemove) {
+			t.Errorf("%s: Remove = %v, want %v", tt.name, got, tt.wantRemove)
+		}
+		if got := names(res.Skipped); !equalStrings(got, tt.wantSkipped) {
+			t.Errorf("%s: Skipped = %v, want %v", tt.name, got, tt.wantSkipped)
+		}
+		for _, tr := range append(res.Remove, res.Skipped...) {
+			if res.KeptOver[tr] == nil {
+				t.Errorf("%s: no version kept over %q", tt.name, tr.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/Kozehh/SpotifyFunc
+
+go 1.22
+
+require (
+	github.com/jinzhu/gorm v1.9.16
+	golang.org/x/oauth2 v0.26.0
+)
+
+require github.com/jinzhu/inflection v1.0.0 // indirect
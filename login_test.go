@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+func TestHeadlessLogin(t *testing.T) {
+	// nothing is a stdin the user never writes to
+	nothing, _ := io.Pipe()
+	defer nothing.Close()
+	defer func(a spotify.Authenticator) { auth = a }(auth)
+
+	tests := []struct {
+		name    string
+		in      io.Reader
+		expires time.Duration
+		wantErr error
+	}{
+		{name: "code pasted", in: strings.NewReader(spotifytest.Code + "\n"), expires: time.Minute},
+		{name: "code pasted without newline", in: strings.NewReader(spotifytest.Code), expires: time.Minute},
+		{name: "nothing pasted before expiry", in: nothing, expires: 50 * time.Millisecond, wantErr: spotify.ErrLoginExpired},
+		{name: "stdin closed", in: strings.NewReader(""), expires: time.Minute, wantErr: io.EOF},
+	}
+	for _, tt := range tests {
+		s := spotifytest.NewServer()
+		defer s.Close()
+		auth = s.Authenticator("http://localhost:8080/callback")
+		l, err := auth.StartLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Expires = time.Now().Add(tt.expires)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			tok, err := headlessLogin(l, tt.in)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				return
+			}
+			if tok.AccessToken != s.Token() {
+				t.Errorf("%s: access token = %q, want %q", tt.name, tok.AccessToken, s.Token())
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: headlessLogin still waiting after the login expired", tt.name)
+		}
+	}
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Kozehh/SpotifyFunc/spotify"
 	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"golang.org/x/oauth2"
 )
 
 const (
@@ -15,99 +24,256 @@ const (
 	ScopeUserFollowRead        = "user-follow-read"
 	ScopeUserFollowModify      = "user-follow-modify"
 	ScopePlaylistModifyPrivate = "playlist-modify-private"
-	// RedirectURI is the OAuth reditect URI for the application
-	RedirectURI = "http://localhost:8080/callback"
-	layoutISO   = "2006-01-02"
+	ScopePlaylistReadPrivate   = "playlist-read-private"
+	// PlaylistDescription is given to the release playlist when it is created
+	PlaylistDescription = "Latest releases of the artists I follow"
+	// UserAgent identifies the application in its API requests
+	UserAgent = "SpotifyFunc"
 )
 
-// Instance of an error type, auth Client and the state
+// Instance of an error type, the authenticator and the metrics
 var (
-	err     error
-	auth    = spotify.NewAuthenticator(RedirectURI, ScopeUserReadPrivate, ScopeUserFollowRead, ScopeUserFollowModify, ScopePlaylistModifyPrivate)
-	channel = make(chan *models.Client)
-	state   = "abc123"
+	err error
+	// auth is made once the flags giving its redirect URI are parsed
+	auth spotify.Authenticator
+	// metrics of the requests of the logged in client, served on /metrics
+	metrics = models.NewMetrics()
+
+	storePath    = flag.String("store", "seen_releases.json", "file keeping track of the releases already added to the playlist")
+	playlist     = flag.String("playlist", "New Releases", "release playlist: a spotify:playlist: URI, an open.spotify.com URL, the name of one of your playlists or a playlist ID, created if missing")
+	pruneDays    = flag.Int("prune-days", 0, "remove tracks older than this many days from the playlist after adding the new ones, 0 keeps everything")
+	pruneBy      = flag.String("prune-by", PruneByRelease, `age of a playlist track: "release" for its album release date, "added" for when it was added`)
+	daemon       = flag.Bool("daemon", false, "keep running and run the release pipeline on the -schedule")
+	scheduleFlag = flag.String("schedule", "fri 06:00", `when the daemon runs: "<day> HH:MM", "daily HH:MM" or "@every <duration>"`)
+	rulesPath    = flag.String("rules", "", "JSON file with the release filtering rules, albums and singles with no other filter if empty")
+	dedup        = flag.String("dedup", DedupAlbum, `version kept when a song is released several times: "album", "earliest" or "off"`)
+	workers      = flag.Int("workers", 8, "number of artists whose albums are fetched at once")
+	dryRun       = flag.Bool("dry-run", false, "print what would be added to and removed from the playlist without changing anything")
+	planFormat   = flag.String("plan-format", FormatTable, `format of the -dry-run plan: "table" or "json"`)
+	planOut      = flag.String("plan-out", "", "with -dry-run, also save the plan to this file so it can be used with -apply")
+	applyPath    = flag.String("apply", "", "apply a plan saved with -plan-out instead of looking for new releases")
+	rate         = flag.Float64("rate", 0, "maximum number of API requests per second, 0 for no limit")
+	windowFlag   = flag.String("window", "30d", `releases to consider new: a number of days ("30d"), "last-run" or a date ("2020-06-01"); a release only known to the month or year is dated to its last day, so one only dated "2020" is not picked up before Dec 31, 2020`)
+	logRequests  = flag.Bool("log-requests", false, "log every request sent to the Spotify API with its status and duration")
+	tokenPath    = flag.String("token", "token.json", "file keeping the login between runs, refreshed automatically; log in with the browser if missing")
+	headless     = flag.Bool("headless", false, "log in without a browser on this machine: open the login URL anywhere and paste back the address you are sent to")
+	redirectPort = flag.Int("redirect-port", 8080, "port of the OAuth redirect URI, also serving /metrics")
+	redirectPath = flag.String("redirect-path", "/callback", "path of the OAuth redirect URI, it must be registered for the app with the port")
+	cacheFlag    = flag.Bool("cache", false, "keep the API responses in memory and revalidate them with their ETag, see -cache-dir to keep them between runs")
+	cacheDir     = flag.String("cache-dir", "", "directory keeping the API responses between runs, revalidated with their ETag; only one user per directory, enables -cache")
 )
 
 func main() {
+	flag.Parse()
 
-	// Calls to the OAuth
-	http.HandleFunc("/callback", completeAuthorization)
+	window, err := ParseReleaseWindow(*windowFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *pruneBy != PruneByRelease && *pruneBy != PruneByAdded {
+		log.Fatalf("Invalid -prune-by %q, expected %q or %q", *pruneBy, PruneByRelease, PruneByAdded)
+	}
+	if *dedup != DedupAlbum && *dedup != DedupEarliest && *dedup != DedupOff {
+		log.Fatalf("Invalid -dedup %q, expected %q, %q or %q", *dedup, DedupAlbum, DedupEarliest, DedupOff)
+	}
+	if *planFormat != FormatTable && *planFormat != FormatJSON {
+		log.Fatalf("Invalid -plan-format %q, expected %q or %q", *planFormat, FormatTable, FormatJSON)
+	}
+	if !strings.HasPrefix(*redirectPath, "/") {
+		log.Fatalf("Invalid -redirect-path %q, it must start with /", *redirectPath)
+	}
+	var sched Schedule
+	if *daemon {
+		if sched, err = ParseSchedule(*scheduleFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	rules := DefaultReleaseRules()
+	if *rulesPath != "" {
+		if rules, err = LoadReleaseRules(*rulesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	store, err := LoadSeenStore(*storePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The redirect URI must match one registered in the Spotify app settings
+	redirectURI := fmt.Sprintf("http://localhost:%d%s", *redirectPort, *redirectPath)
+	auth = newAuthenticator(redirectURI, ScopeUserReadPrivate, ScopeUserFollowRead, ScopeUserFollowModify, ScopePlaylistModifyPrivate, ScopePlaylistReadPrivate)
+
+	// Calls to the OAuth, the headless logins are completed with what the user pastes instead
+	if !*headless {
+		http.HandleFunc(*redirectPath, completeAuthorization)
+	}
 
 	// Register the handle function with the 'Get User's Followed Artist' pattern
 	//http.HandleFunc("/me/following?type=artist", func(w http.ResponseWriter, r *http.Request) {})
 	//http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
-	go http.ListenAndServe(":8080", nil)
-
-	url := auth.AuthURL(state)
-	fmt.Println("Please log in to Spotify : ", url)
-
-	//wait for the auth to complete
-	client := <-channel
-
-	GetCurrentUser(client)
-
-	// Get the list of all the artists followed
-	followedArtists := GetFollowedArtists(client)
-
-	latestReleasedAlbum := GetFollowedArtistsLatest(followedArtists, client)
+	// The metrics of the API calls, for Prometheus to scrape when running as a service
+	http.Handle("/metrics", metrics)
+	go http.ListenAndServe(fmt.Sprintf(":%d", *redirectPort), nil)
 
-	AddLatestReleasesToPlaylist(latestReleasedAlbum, client)
-	//PrintFollowedArtists(artists)
+	client, err := login(spotify.NewFileTokenStore(*tokenPath), os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Albums are fetched concurrently, wait instead of failing when the rate limit is exceeded
+	client.AutoRetry = true
+	if *rate > 0 {
+		client.Limiter = models.NewRateLimiter(*rate, int(*rate)+1)
+	}
+	// The albums of the same artists are fetched run after run, mostly unchanged
+	switch {
+	case *cacheDir != "":
+		cache, err := models.NewDiskCache(*cacheDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client.Cache = cache
+	case *cacheFlag:
+		client.Cache = models.NewMemoryCache(1000)
+	}
 
-}
+	pipeline := &Pipeline{
+		Client:    client,
+		Store:     store,
+		Playlist:  *playlist,
+		Window:    window,
+		Rules:     rules,
+		Dedup:     *dedup,
+		Workers:   *workers,
+		PruneDays: *pruneDays,
+		PruneBy:   *pruneBy,
+	}
 
-func AddLatestReleasesToPlaylist(latestReleasedAlbum []*models.SimplifiedAlbumObject, c *models.Client) {
-	var newReleasedTracks = []*models.Track{}
-	for _, l := range latestReleasedAlbum {
-		tracks, err := c.GetAlbumTracks(l.ID, 50)
+	switch {
+	case *applyPath != "":
+		plan, err := LoadPlan(*applyPath)
 		if err != nil {
 			log.Fatal(err)
 		}
-		newReleasedTracks = append(newReleasedTracks, tracks...)
+		summary, err := pipeline.Apply(plan)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Done,", summary)
+	case *dryRun:
+		plan, err := pipeline.Plan()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := plan.Write(os.Stdout, *planFormat); err != nil {
+			log.Fatal(err)
+		}
+		if *planOut != "" {
+			if err := plan.Save(*planOut); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case *daemon:
+		// The client refreshes its token by itself, so a single login is enough for the daemon
+		RunDaemon(pipeline, sched)
+	default:
+		summary, err := pipeline.Run()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Done,", summary)
+		if client.Limiter != nil {
+			fmt.Println("Rate limiter:", client.Limiter.Stats())
+		}
 	}
-	c.AddLatestToPlaylist(newReleasedTracks)
+	//PrintFollowedArtists(artists)
+
 }
 
-func GetFollowedArtistsLatest(followedArtists []models.Artist, client *models.Client) []*models.SimplifiedAlbumObject {
+// GetFollowedArtistsLatest : Returns the albums released since the given time that pass the rules
+// and were not already processed for the user in a previous run.
+// The new albums left out by the rules are returned with the reason why,
+// and the artists whose albums could not be fetched with their error.
+func GetFollowedArtistsLatest(followedArtists []models.Artist, client *models.Client, store *SeenStore, user *models.PrivateUser, since time.Time, rules ReleaseRules, workers int) ([]*models.SimplifiedAlbumObject, []*PlannedAlbum, []*ArtistError) {
 	var newReleases = []*models.SimplifiedAlbumObject{}
+	var skipped []*PlannedAlbum
+	// An album featuring several followed artists is returned once per artist
+	picked := map[string]bool{}
+	now := time.Now()
 
-	artistsAlbums := GetFollowedArtistAlbums(client, followedArtists)
+	artistsAlbums, artistErrs := GetFollowedArtistAlbums(client, followedArtists, rules.Groups(), workers)
 	for _, aa := range artistsAlbums {
-		// Check if albums released less than a month ago
-		if isNew := GetMonthyReleases(aa); isNew {
-			// if so add to newReleases slice
-			// TODO : Should have a bd or keep track of the slice containing the latest releases
-			// so that if I run the code two days in a row I only get the ones that released in the past day
-			newReleases = append(newReleases, aa)
+		if picked[aa.ID] || store.Seen(user.ID, aa.ID) {
+			continue
+		}
+		if !aa.ReleaseDate.Valid() {
+			picked[aa.ID] = true
+			skipped = append(skipped, newPlannedAlbum(aa, "invalid release date"))
+			continue
+		}
+		if isNew := IsNewRelease(aa, since, now); !isNew {
+			continue
+		}
+		picked[aa.ID] = true
+		if ok, reason := rules.AllowAlbum(aa, user.Country); !ok {
+			skipped = append(skipped, newPlannedAlbum(aa, reason))
+			continue
 		}
+		newReleases = append(newReleases, aa)
 	}
-	return newReleases
+	return newReleases, skipped, artistErrs
 }
 
-// GetMonthyReleases : Check if albums released less than a month ago
-// TODO: Should implement a way to fetch a sclice (newReleases) to only return
-// the ones that were not been fetch allready this month from another use of the function
-func GetMonthyReleases(artistAlbum *models.SimplifiedAlbumObject) bool {
-	formReleaseDate, _ := time.Parse(layoutISO, artistAlbum.ReleaseDate)
-	timeDiff := time.Since(formReleaseDate)
-	if timeDiff.Hours() < 730 {
-		return true
-	}
-	return false
+// ArtistError : Failure to fetch the albums of a followed artist
+type ArtistError struct {
+	Artist models.Artist
+	Err    error
+}
+
+func (e *ArtistError) Error() string {
+	return fmt.Sprintf("albums of %s (%s): %v", e.Artist.Name, e.Artist.ID, e.Err)
 }
 
-// GetArtistAlbums : Get all the albums of artists
-func GetFollowedArtistAlbums(client *models.Client, artists []models.Artist) []*models.SimplifiedAlbumObject {
+// GetFollowedArtistAlbums : Get all the albums of artists in the album groups, fetching the albums of
+// up to `workers` artists at once. The albums are returned in the order of artists whatever the order
+// the requests complete in. An artist whose albums cannot be fetched does not stop the others,
+// its error is returned in the second value.
+func GetFollowedArtistAlbums(client *models.Client, artists []models.Artist, groups []string, workers int) ([]*models.SimplifiedAlbumObject, []*ArtistError) {
+	if workers < 1 {
+		workers = 1
+	}
+	// Each worker writes to the index of its artist, so no locking is needed
+	results := make([][]*models.SimplifiedAlbumObject, len(artists))
+	errs := make([]error, len(artists))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The client waits and retries on its own when the rate limit is exceeded
+			for i := range indexes {
+				results[i], errs[i] = client.GetArtistAlbums(artists[i].ID, -1, groups...)
+			}
+		}()
+	}
+	for i := range artists {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
 	var allAlbums = []*models.SimplifiedAlbumObject{}
-	for _, a := range artists {
-		result, err := client.GetArtistAlbums(a.ID)
-		if err != nil {
-			log.Fatal(err)
+	var artistErrs []*ArtistError
+	for i, result := range results {
+		if errs[i] != nil {
+			artistErrs = append(artistErrs, &ArtistError{Artist: artists[i], Err: errs[i]})
+			continue
 		}
 		allAlbums = append(allAlbums, result...)
-		//PrintArtistWithAlbums(a, result)
+		//PrintArtistWithAlbums(artists[i], result)
 	}
-	return allAlbums
+	return allAlbums, artistErrs
 }
 
 func PrintArtistWithAlbums(a models.Artist, albums []*models.SimplifiedAlbumObject) {
@@ -117,32 +283,19 @@ func PrintArtistWithAlbums(a models.Artist, albums []*models.SimplifiedAlbumObje
 	}
 }
 
-func GetCurrentUser(client *models.Client) {
+func GetCurrentUser(client *models.Client) (*models.PrivateUser, error) {
 	// use the client to make calls that require authorization
 	user, err := client.CurrentUser()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	fmt.Println("You are logged in as : ", user.DisplayName)
+	// stdout is kept for the -dry-run plan
+	fmt.Fprintln(os.Stderr, "You are logged in as : ", user.DisplayName)
+	return user, nil
 }
 
-func GetFollowedArtists(client *models.Client) []models.Artist {
-	var lastArtistID = ""
-	var artistList = []models.Artist{}
-	for {
-		// Get the a list of followed artists
-		artists, err := client.GetFollowedArtists(50, lastArtistID)
-		artistList = append(artistList, artists.Artists...)
-		if err != nil {
-			log.Fatal(err)
-		}
-		// If there is no other pages, break out of the loop
-		if artists.CursorBasedObj.Next == "" {
-			break
-		}
-		lastArtistID = artistList[len(artistList)-1].ID
-	}
-	return artistList
+func GetFollowedArtists(client *models.Client) ([]models.Artist, error) {
+	return client.IterFollowedArtists(context.Background()).All()
 }
 
 func PrintFollowedArtists(artists []models.Artist) {
@@ -151,22 +304,109 @@ func PrintFollowedArtists(artists []models.Artist) {
 	}
 }
 
+// newAuthenticator uses the PKCE flow when SPOTIFY_SECRET is not set,
+// so the app can be given to other people without its client secret
+func newAuthenticator(redirectURI string, scopes ...string) spotify.Authenticator {
+	if os.Getenv("SPOTIFY_SECRET") == "" {
+		return spotify.NewPKCEAuthenticator(redirectURI, scopes...)
+	}
+	return spotify.NewAuthenticator(redirectURI, scopes...)
+}
+
+// completeAuthorization handles the redirects of the logins, which may be several at once
 func completeAuthorization(w http.ResponseWriter, r *http.Request) {
+	// The token goes to the login waiting for it
+	if _, err := auth.CompleteLogin(r); err != nil {
+		http.Error(w, "Couldn't get token.", http.StatusForbidden)
+		log.Println(err)
+		return
+	}
+	fmt.Fprintf(w, "Login Completed!")
+}
+
+// login returns a client for the user, using the saved token when there is one.
+// The browser login is only needed the first time, or once the saved token is revoked.
+// The headless login reads what the user pastes from in.
+func login(tokens spotify.TokenStore, in io.Reader) (*models.Client, error) {
+	middlewares := []models.Middleware{models.WithUserAgent(UserAgent)}
+	if *logRequests {
+		middlewares = append(middlewares, models.WithRequestID(), models.WithLogging(nil))
+	}
 
-	// Get the token using the new authenticator
-	tok, err := auth.Token(state, r)
+	tok, err := tokens.Load()
 	if err != nil {
-		http.Error(w, "Couldn't get token.", http.StatusForbidden)
-		log.Fatal(err)
+		return nil, err
 	}
-	if st := r.FormValue("state"); st != state {
-		http.NotFound(w, r)
-		log.Fatalf("State mismatch : %s != %s\n", st, state)
+	if tok != nil {
+		client, err := auth.NewStoredClient(tok, tokens, middlewares...)
+		if err != nil {
+			return nil, err
+		}
+		client.Metrics = metrics
+		// Refreshes the token if it expired, which fails if it was revoked
+		_, err = client.CurrentUser()
+		var refreshErr *oauth2.RetrieveError
+		switch {
+		case err == nil:
+			return &client, nil
+		case errors.Is(err, models.ErrUnauthorized) || errors.As(err, &refreshErr):
+			log.Println("The saved login is not valid anymore:", err)
+		default:
+			return nil, err
+		}
 	}
 
-	// if the state returned is good and we received a token
-	// Use the token to get an authenticated client
-	client := auth.NewClient(tok)
-	fmt.Fprintf(w, "Login Completed!")
-	channel <- &client
+	l, err := auth.StartLogin()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(os.Stderr, "Please log in to Spotify : ", l.URL)
+
+	if *headless {
+		tok, err = headlessLogin(l, in)
+	} else {
+		//wait for the auth to complete
+		tok, err = l.Wait(context.Background())
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := auth.NewStoredClient(tok, tokens, middlewares...)
+	if err != nil {
+		return nil, err
+	}
+	client.Metrics = metrics
+	return &client, nil
+}
+
+// headlessLogin completes the login with what the user pastes in, as the page the browser
+// is sent to after the login can't be loaded when it runs on another machine.
+// Fails with spotify.ErrLoginExpired if nothing was pasted before the login expired.
+func headlessLogin(l *spotify.Login, in io.Reader) (*oauth2.Token, error) {
+	fmt.Fprintln(os.Stderr, "Once logged in, the browser fails to load a localhost page.")
+	fmt.Fprint(os.Stderr, "Paste the address of that page, or only its code: ")
+
+	type pasted struct {
+		line string
+		err  error
+	}
+	// Reading can't be interrupted, the goroutine is left waiting if the login expires first
+	lines := make(chan pasted, 1)
+	go func() {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		lines <- pasted{line, err}
+	}()
+
+	timer := time.NewTimer(time.Until(l.Expires))
+	defer timer.Stop()
+	select {
+	case p := <-lines:
+		if p.err != nil && p.line == "" {
+			return nil, p.err
+		}
+		return auth.CompleteLoginFrom(l, p.line)
+	case <-timer.C:
+		fmt.Fprintln(os.Stderr)
+		return nil, spotify.ErrLoginExpired
+	}
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify"
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+	"golang.org/x/oauth2"
+)
+
+func TestGetFollowedArtistAlbums(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+
+	var artists []models.Artist
+	var want []string
+	for i := 0; i < 8; i++ {
+		if i == 3 {
+			// Not an artist of the server, its albums are not found
+			artists = append(artists, models.Artist{ID: "missing", Name: "Missing"})
+		}
+		a := s.FollowArtist(models.Artist{Name: fmt.Sprintf("Artist %d", i)})
+		artists = append(artists, a)
+		for j := 0; j < 3; j++ {
+			name := fmt.Sprintf("Album %d-%d", i, j)
+			s.AddAlbum(a, models.SimplifiedAlbumObject{Name: name, ReleaseDate: releaseDay(time.Now())})
+			want = append(want, name)
+		}
+	}
+
+	client := s.Client()
+	// The first artists are answered last, so the requests complete out of order
+	client.Use(func(next http.RoundTripper) http.RoundTripper {
+		return models.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			for i, a := range artists {
+				if strings.Contains(req.URL.Path, "/artists/"+a.ID+"/") {
+					time.Sleep(time.Duration(len(artists)-i) * 5 * time.Millisecond)
+				}
+			}
+			return next.RoundTrip(req)
+		})
+	})
+
+	for _, workers := range []int{1, 4} {
+		albums, errs := GetFollowedArtistAlbums(client, artists, nil, workers)
+		got := make([]string, 0, len(albums))
+		for _, a := range albums {
+			got = append(got, a.Name)
+		}
+		if !equalStrings(got, want) {
+			t.Errorf("%d workers: albums = %v, want %v", workers, got, want)
+		}
+		if len(errs) != 1 || errs[0].Artist.ID != "missing" || !errors.Is(errs[0].Err, models.ErrNotFound) {
+			t.Errorf("%d workers: errors = %v, want the albums of Missing not found", workers, errs)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	defer func(a spotify.Authenticator, h bool) { auth, *headless = a, h }(auth, *headless)
+	auth = s.Authenticator("http://localhost:8080/callback")
+	*headless = true
+
+	expired := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name  string
+		saved *oauth2.Token
+		// pasted is what the user pastes to the headless login, nothing if it must not be asked for
+		pasted string
+	}{
+		{name: "no saved token", pasted: spotifytest.Code + "\n"},
+		{name: "saved token", saved: &oauth2.Token{AccessToken: s.Token(), TokenType: "Bearer", RefreshToken: spotifytest.RefreshToken, Expiry: time.Now().Add(time.Hour)}},
+		{name: "saved token refreshed", saved: &oauth2.Token{AccessToken: "expired", TokenType: "Bearer", RefreshToken: spotifytest.RefreshToken, Expiry: expired}},
+		// The refresh fails with a *oauth2.RetrieveError, the user logs in again
+		{name: "saved token revoked", saved: &oauth2.Token{AccessToken: "expired", TokenType: "Bearer", RefreshToken: "revoked", Expiry: expired}, pasted: spotifytest.Code + "\n"},
+		{name: "saved token refused", saved: &oauth2.Token{AccessToken: "revoked", TokenType: "Bearer", RefreshToken: spotifytest.RefreshToken, Expiry: time.Now().Add(time.Hour)}, pasted: spotifytest.Code + "\n"},
+	}
+	for _, tt := range tests {
+		tokens := spotify.NewFileTokenStore(filepath.Join(t.TempDir(), "token.json"))
+		if tt.saved != nil {
+			if err := tokens.Save(tt.saved); err != nil {
+				t.Fatal(err)
+			}
+		}
+		client, err := login(tokens, strings.NewReader(tt.pasted))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if _, err := client.CurrentUser(); err != nil {
+			t.Errorf("%s: the client can't be used: %v", tt.name, err)
+		}
+		tok, err := tokens.Load()
+		if err != nil || tok.AccessToken != s.Token() || tok.RefreshToken != spotifytest.RefreshToken {
+			t.Errorf("%s: saved %+v, %v, want the token of the server", tt.name, tok, err)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+// Pipeline : Settings of the release pipeline, which adds the latest releases
+// of the followed artists to the release playlist
+type Pipeline struct {
+	Client *models.Client
+	Store  *SeenStore
+	// Playlist is the ID, URI or name of the release playlist
+	Playlist string
+	Window   ReleaseWindow
+	Rules    ReleaseRules
+	// Dedup is the version kept when a song is released several times, see DedupTracks
+	Dedup string
+	// Workers is the number of artists whose albums are fetched at once
+	Workers int
+	// PruneDays removes the tracks older than this many days after adding the new ones, 0 disables it
+	PruneDays int
+	PruneBy   string
+}
+
+// RunSummary : What a run of the pipeline did
+type RunSummary struct {
+	Started  time.Time
+	Finished time.Time
+	User     string
+	// Artists is the number of followed artists
+	Artists int
+	// Releases is the number of new albums added to the playlist
+	Releases int
+	// Tracks is the number of tracks added to the playlist
+	Tracks int
+	// Removed is the number of tracks removed from the playlist, old or replaced by another version
+	Removed int
+	// FailedArtists are the artists whose albums could not be fetched
+	FailedArtists []string
+}
+
+func (s *RunSummary) String() string {
+	return fmt.Sprintf("%s: %d followed artists (%d failed), %d new releases, %d tracks added, %d tracks removed in %s",
+		s.User, s.Artists, len(s.FailedArtists), s.Releases, s.Tracks, s.Removed, s.Finished.Sub(s.Started).Round(time.Second))
+}
+
+// Run : Runs the pipeline once, building the plan and applying it right away
+func (p *Pipeline) Run() (summary *RunSummary, err error) {
+	started := time.Now()
+	// A bug in a single run must not bring down a long-running process
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("release pipeline panicked: %v", r)
+		}
+		if summary == nil {
+			summary = &RunSummary{}
+		}
+		summary.Started = started
+		summary.Finished = time.Now()
+	}()
+
+	plan, err := p.Plan()
+	if err != nil {
+		return nil, err
+	}
+	return p.Apply(plan)
+}
+
+// Plan : Looks for the new releases and decides what to add to and remove from the playlist,
+// without modifying anything on Spotify or in the store
+func (p *Pipeline) Plan() (*Plan, error) {
+	plan := &Plan{CreatedAt: time.Now()}
+
+	user, err := GetCurrentUser(p.Client)
+	if err != nil {
+		return nil, err
+	}
+	plan.UserID, plan.UserName = user.ID, user.DisplayName
+
+	playlistID, found, err := p.Client.FindPlaylist(p.Playlist, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		plan.PlaylistID = playlistID
+	} else {
+		plan.PlaylistName = p.Playlist
+	}
+
+	// Get the list of all the artists followed
+	followedArtists, err := GetFollowedArtists(p.Client)
+	if err != nil {
+		return nil, err
+	}
+	plan.Artists = len(followedArtists)
+
+	since := p.Window.Start(plan.CreatedAt, p.Store.LastRun(user.ID))
+	latestReleasedAlbum, skipped, artistErrs := GetFollowedArtistsLatest(followedArtists, p.Client, p.Store, user, since, p.Rules, p.Workers)
+	for _, e := range artistErrs {
+		log.Println(e)
+		plan.FailedArtists = append(plan.FailedArtists, e.Artist.Name)
+	}
+	// When nothing could be fetched the cause is not the artists, likely an expired token or a network issue
+	if len(artistErrs) > 0 && len(artistErrs) == len(followedArtists) {
+		return nil, fmt.Errorf("couldn't fetch the albums of any followed artist: %v", artistErrs[0].Err)
+	}
+	plan.SkippedAlbums = skipped
+
+	// The current content of the playlist, to avoid duplicates and find the old tracks. It is read
+	// even without dedup nor pruning: an album partly added by a failed run is not seen yet,
+	// its tracks already in the playlist must not be added again.
+	var items []models.PlaylistTrack
+	if found {
+		// The positions of the tracks to remove are in this version of the playlist
+		playlist, err := p.Client.GetPlaylist(playlistID)
+		if err != nil {
+			return nil, err
+		}
+		plan.SnapshotID = playlist.SnapshotID
+		if items, err = p.Client.GetPlaylistTracks(playlistID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.planTracks(plan, latestReleasedAlbum, items, user.Country); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// planTracks decides what happens to each track of the new releases and to the tracks of the playlist
+func (p *Pipeline) planTracks(plan *Plan, releases []*models.SimplifiedAlbumObject, items []models.PlaylistTrack, country string) error {
+	// Why a track of the new releases is not added
+	reasons := map[*models.Track]string{}
+	inPlaylist := map[string]bool{}
+	for _, item := range items {
+		if item.Track != nil {
+			inPlaylist[item.Track.URI] = true
+		}
+	}
+	tracksByAlbum := make([][]*models.Track, len(releases))
+	var candidates []*models.Track
+	for i, l := range releases {
+		tracks, err := p.Client.GetAlbumTracks(l.ID, -1)
+		if err != nil {
+			return err
+		}
+		tracksByAlbum[i] = tracks
+		for _, t := range tracks {
+			// Album tracks don't tell which album they are from
+			t.Album = l
+			if ok, reason := p.Rules.AllowTrack(t, country); !ok {
+				reasons[t] = reason
+				continue
+			}
+			if inPlaylist[t.URI] {
+				reasons[t] = "already in the playlist"
+				continue
+			}
+			// Adding a track the pruning removes right away would be pointless
+			if p.PruneDays > 0 && p.PruneBy == PruneByRelease && isExpired(models.PlaylistTrack{Track: t}, PruneByRelease, plan.CreatedAt.AddDate(0, 0, -p.PruneDays)) {
+				reasons[t] = fmt.Sprintf("older than %d days", p.PruneDays)
+				continue
+			}
+			candidates = append(candidates, t)
+		}
+	}
+
+	// The tracks to remove by URI, and the positions already removed
+	removed := map[string]*PlannedTrack{}
+	removedAt := map[int]bool{}
+	remove := func(position int, reason string, replacedBy *models.Track) {
+		if removedAt[position] {
+			return
+		}
+		removedAt[position] = true
+		t := items[position].Track
+		planned, ok := removed[t.URI]
+		if !ok {
+			planned = removedTrack(t, reason)
+			if replacedBy != nil {
+				planned.ReplacedBy = replacedBy.URI
+			}
+			removed[t.URI] = planned
+			plan.Remove = append(plan.Remove, planned)
+		}
+		planned.Positions = append(planned.Positions, position)
+	}
+
+	if p.Dedup != DedupOff {
+		var existing []*models.Track
+		positions := map[*models.Track]int{}
+		for i, item := range items {
+			if item.Track != nil {
+				existing = append(existing, item.Track)
+				positions[item.Track] = i
+			}
+		}
+		res, err := DedupTracks(p.Client, existing, candidates, p.Dedup)
+		if err != nil {
+			return err
+		}
+		for _, t := range res.Skipped {
+			reasons[t] = keptReason("same song as", res.KeptOver[t])
+		}
+		for _, t := range res.Remove {
+			remove(positions[t], keptReason("replaced by", res.KeptOver[t]), res.KeptOver[t])
+		}
+	}
+
+	if p.PruneDays > 0 {
+		expired, err := ExpiredTracks(items, p.PruneDays, p.PruneBy, plan.CreatedAt)
+		if err != nil {
+			return err
+		}
+		for _, position := range expired {
+			remove(position, fmt.Sprintf("older than %d days", p.PruneDays), nil)
+		}
+	}
+
+	for i, l := range releases {
+		album := newPlannedAlbum(l, "")
+		for _, t := range tracksByAlbum[i] {
+			if reason, ok := reasons[t]; ok {
+				album.Tracks = append(album.Tracks, newPlannedTrack(t, ActionSkip, reason))
+			} else {
+				album.Tracks = append(album.Tracks, newPlannedTrack(t, ActionAdd, ""))
+			}
+		}
+		plan.Albums = append(plan.Albums, album)
+	}
+	return nil
+}
+
+func removedTrack(t *models.Track, reason string) *PlannedTrack {
+	planned := newPlannedTrack(t, ActionRemove, reason)
+	if t.Album != nil {
+		planned.Album = t.Album.Name
+	}
+	return planned
+}
+
+// keptReason tells which version of a song is kept
+func keptReason(prefix string, kept *models.Track) string {
+	if kept == nil || kept.Album == nil {
+		return prefix + " another version"
+	}
+	return fmt.Sprintf("%s %q on %q", prefix, kept.Name, kept.Album.Name)
+}
+
+// Apply : Executes the plan, creating the playlist if needed, adding and then removing its tracks.
+// The store is only updated when the releases made it to the playlist,
+// so a failed run is retried entirely by the next one.
+func (p *Pipeline) Apply(plan *Plan) (*RunSummary, error) {
+	summary := &RunSummary{
+		Started:       time.Now(),
+		User:          plan.UserName,
+		Artists:       plan.Artists,
+		FailedArtists: plan.FailedArtists,
+	}
+
+	user, err := p.Client.CurrentUser()
+	if err != nil {
+		return summary, err
+	}
+	if user.ID != plan.UserID {
+		return summary, fmt.Errorf("the plan was made for user %s, logged in as %s", plan.UserID, user.ID)
+	}
+
+	playlistID := plan.PlaylistID
+	if playlistID == "" {
+		created, err := p.Client.CreatePlaylist(plan.UserID, plan.PlaylistName, PlaylistDescription, false)
+		if err != nil {
+			return summary, err
+		}
+		playlistID = created.ID
+	}
+
+	add := plan.TracksToAdd()
+	snapshotID, addErr := p.Client.AddTracksToPlaylist(playlistID, add)
+	// Some batches may have made it to the playlist, the albums they hold must not be added again
+	failed := map[string]bool{}
+	var partial *models.PartialAddError
+	switch {
+	case errors.As(addErr, &partial):
+		for _, uri := range partial.FailedURIs() {
+			failed[uri] = true
+		}
+		summary.Tracks = partial.Added
+		snapshotID = partial.SnapshotID
+	case addErr != nil:
+		return summary, addErr
+	default:
+		summary.Tracks = len(add)
+	}
+
+	// The tracks are removed once their replacement is in the playlist. The additions went to
+	// the end of the playlist, the positions of the tracks to remove are still the planned ones.
+	// A failed removal is retried by the next run, the additions are remembered all the same.
+	var removeErr error
+	if remove := plan.TracksToRemove(failed); len(remove) > 0 {
+		if snapshotID == "" {
+			snapshotID = plan.SnapshotID
+		}
+		if _, removeErr = p.Client.RemoveTracksFromPlaylist(playlistID, snapshotID, remove); removeErr == nil {
+			summary.Removed = removals(remove)
+		}
+	}
+
+	// Only remember the releases once they made it to the playlist
+	for _, a := range plan.Albums {
+		if albumAdded(a, failed) {
+			summary.Releases++
+			p.Store.MarkSeen(plan.UserID, a.ID)
+		}
+	}
+	// The releases of the failed artists are still to be looked at by a "since last run" window
+	if plan.Complete() && addErr == nil {
+		p.Store.SetLastRun(plan.UserID, plan.CreatedAt)
+	}
+	if err := p.Store.Save(); err != nil {
+		return summary, err
+	}
+	summary.Finished = time.Now()
+	return summary, errors.Join(addErr, removeErr)
+}
+
+// albumAdded reports whether none of the tracks of the album to add failed
+func albumAdded(a *PlannedAlbum, failed map[string]bool) bool {
+	for _, t := range a.Tracks {
+		if t.Action == ActionAdd && failed[t.URI] {
+			return false
+		}
+	}
+	return true
+}
+
+// RunDaemon : Runs the pipeline every time the schedule is due, forever.
+// A failed run is logged and the next one still happens.
+func RunDaemon(p *Pipeline, sched Schedule) {
+	for {
+		next := sched.Next(time.Now())
+		log.Printf("Next release run at %s\n", next.Format(time.RFC1123))
+		time.Sleep(time.Until(next))
+
+		summary, err := p.Run()
+		if err != nil {
+			log.Printf("Release run failed after %s: %v\n", summary.Finished.Sub(summary.Started).Round(time.Second), err)
+			continue
+		}
+		log.Printf("Release run done, %s\n", summary)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+func newTestPipeline(t *testing.T, s *spotifytest.Server) *Pipeline {
+	store, err := LoadSeenStore(filepath.Join(t.TempDir(), "seen.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Pipeline{
+		Client:   s.Client(),
+		Store:    store,
+		Playlist: "New Releases",
+		Window:   ReleaseWindow{Kind: WindowDays, Days: 30},
+		Rules:    DefaultReleaseRules(),
+		Dedup:    DedupOff,
+		Workers:  2,
+		PruneBy:  PruneByRelease,
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	s.Seed()
+	p := newTestPipeline(t, s)
+
+	summary, err := p.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The album and the new single of both artists, not the old singles
+	if summary.Releases != 4 || summary.Tracks != 8 {
+		t.Errorf("first run added %d releases and %d tracks, want 4 and 8", summary.Releases, summary.Tracks)
+	}
+	id, _, err := p.Client.FindPlaylist("New Releases", "spotifytest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.PlaylistTracks(id); len(got) != 8 {
+		t.Errorf("playlist holds %d tracks, want 8", len(got))
+	}
+
+	summary, err = p.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Releases != 0 || summary.Tracks != 0 {
+		t.Errorf("second run added %d releases and %d tracks, want none", summary.Releases, summary.Tracks)
+	}
+	if got := s.PlaylistTracks(id); len(got) != 8 {
+		t.Errorf("playlist holds %d tracks after the second run, want 8", len(got))
+	}
+}
+
+func TestPipelinePlanIsReadOnly(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	s.Seed()
+	p := newTestPipeline(t, s)
+	p.Playlist = "Missing"
+	p.Rules.Explicit = ExplicitExclude
+
+	plan, err := p.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.PlaylistID != "" || plan.PlaylistName != "Missing" {
+		t.Errorf("plan playlist = %q %q, want one to create named Missing", plan.PlaylistID, plan.PlaylistName)
+	}
+	// The explicit outro of both albums is skipped
+	if got := len(plan.TracksToAdd()); got != 6 {
+		t.Errorf("plan adds %d tracks, want 6", got)
+	}
+	if id, _, _ := p.Client.FindPlaylist("Missing", "spotifytest"); id != "" {
+		t.Error("Plan created the playlist")
+	}
+
+	summary, err := p.Apply(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, found, err := p.Client.FindPlaylist("Missing", "spotifytest")
+	if err != nil || !found {
+		t.Fatalf("Apply did not create the playlist: %v", err)
+	}
+	if got := s.PlaylistTracks(id); len(got) != 6 || summary.Tracks != 6 {
+		t.Errorf("playlist holds %d tracks, summary says %d, want 6", len(got), summary.Tracks)
+	}
+}
+
+// TestPipelineApplyReplacement replaces a single already in the playlist by its album version,
+// which must only be removed once the album version is added
+func TestPipelineApplyReplacement(t *testing.T) {
+	tests := []struct {
+		name string
+		// successfulAdds is the number of additions before they fail, -1 for none
+		successfulAdds int
+		bigAlbum       bool
+		want           []string
+		wantSeen       []string
+		wantErr        bool
+	}{
+		{
+			name:           "added",
+			successfulAdds: -1,
+			want:           []string{"spotify:track:other", "spotify:track:album-song", "spotify:track:album-intro"},
+			wantSeen:       []string{"single", "album"},
+		},
+		{
+			name:           "add failed",
+			successfulAdds: 0,
+			want:           []string{"spotify:track:single-song", "spotify:track:other"},
+			wantSeen:       []string{"single"},
+			wantErr:        true,
+		},
+		{
+			name:           "second batch failed",
+			successfulAdds: 1,
+			bigAlbum:       true,
+			want:           append([]string{"spotify:track:single-song", "spotify:track:other"}, bigAlbumTracks()...),
+			wantSeen:       []string{"single", "big"},
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := spotifytest.NewServer()
+			defer s.Close()
+			now := time.Now()
+			a := s.FollowArtist(models.Artist{Name: "Artist"})
+			s.AddAlbum(a, models.SimplifiedAlbumObject{ID: "single", Name: "Song", AlbumType: "single", ReleaseDate: releaseDay(now.AddDate(0, 0, -10))},
+				models.Track{ID: "single-song", Name: "Song", ExternalIDs: map[string]string{"isrc": "ISRC1"}})
+			if tt.bigAlbum {
+				var tracks []models.Track
+				for i := 0; i < 100; i++ {
+					tracks = append(tracks, models.Track{ID: fmt.Sprintf("big-%d", i), Name: fmt.Sprintf("Big %d", i)})
+				}
+				s.AddAlbum(a, models.SimplifiedAlbumObject{ID: "big", Name: "Big", ReleaseDate: releaseDay(now.AddDate(0, 0, -2))}, tracks...)
+			}
+			s.AddAlbum(a, models.SimplifiedAlbumObject{ID: "album", Name: "Album", ReleaseDate: releaseDay(now.AddDate(0, 0, -1))},
+				models.Track{ID: "album-song", Name: "Song", ExternalIDs: map[string]string{"isrc": "ISRC1"}},
+				models.Track{ID: "album-intro", Name: "Intro"})
+			id := s.AddPlaylist("New Releases", "spotify:track:single-song", "spotify:track:other")
+
+			p := newTestPipeline(t, s)
+			p.Dedup = DedupAlbum
+			p.Store.MarkSeen("spotifytest", "single")
+			if tt.successfulAdds >= 0 {
+				s.FailAfter("POST", tt.successfulAdds, http.StatusInternalServerError, 1)
+			}
+
+			_, err := p.Run()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := s.PlaylistTracks(id); !equalStrings(got, tt.want) {
+				t.Errorf("playlist holds %v, want %v", got, tt.want)
+			}
+			for _, album := range []string{"single", "big", "album"} {
+				if seen, want := p.Store.Seen("spotifytest", album), contains(tt.wantSeen, album); seen != want {
+					t.Errorf("album %s seen = %v, want %v", album, seen, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPipelineApplyPartialError(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	a := s.FollowArtist(models.Artist{Name: "Artist"})
+	var tracks []models.Track
+	for i := 0; i < 150; i++ {
+		tracks = append(tracks, models.Track{Name: fmt.Sprintf("Track %d", i)})
+	}
+	s.AddAlbum(a, models.SimplifiedAlbumObject{ID: "long", ReleaseDate: releaseDay(time.Now())}, tracks...)
+	id := s.AddPlaylist("New Releases")
+	p := newTestPipeline(t, s)
+	s.FailAfter("POST", 1, http.StatusInternalServerError, 1)
+
+	summary, err := p.Run()
+	var partial *models.PartialAddError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Run() error = %v, want a *PartialAddError", err)
+	}
+	if summary.Tracks != 100 || summary.Releases != 0 {
+		t.Errorf("summary says %d tracks and %d releases added, want 100 and 0", summary.Tracks, summary.Releases)
+	}
+	if p.Store.Seen("spotifytest", "long") {
+		t.Error("the album only partly added is seen")
+	}
+
+	// The next run only adds the tracks left out, the playlist is read even without dedup nor pruning
+	summary, err = p.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Tracks != 50 || summary.Releases != 1 {
+		t.Errorf("second run says %d tracks and %d releases added, want 50 and 1", summary.Tracks, summary.Releases)
+	}
+	if got := s.PlaylistTracks(id); len(got) != 150 || len(uniqueStrings(got)) != 150 {
+		t.Errorf("playlist holds %d tracks, %d different ones, want the 150 of the album once", len(got), len(uniqueStrings(got)))
+	}
+	if !p.Store.Seen("spotifytest", "long") {
+		t.Error("the album is not seen once all its tracks were added")
+	}
+}
+
+func uniqueStrings(s []string) map[string]bool {
+	unique := map[string]bool{}
+	for _, v := range s {
+		unique[v] = true
+	}
+	return unique
+}
+
+func bigAlbumTracks() []string {
+	var uris []string
+	for i := 0; i < 100; i++ {
+		uris = append(uris, fmt.Sprintf("spotify:track:big-%d", i))
+	}
+	return uris
+}
+
+func releaseDay(t time.Time) models.ReleaseDate {
+	d, _ := models.ParseReleaseDate(t.Format(models.DateLayout))
+	return d
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+// What happens to a track of the plan
+const (
+	ActionAdd    = "add"
+	ActionSkip   = "skip"
+	ActionRemove = "remove"
+)
+
+// Formats a plan can be printed in
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// Plan : Everything a run of the release pipeline changes in the playlist.
+// It is built without modifying anything, so it can be reviewed, saved and applied later as is.
+type Plan struct {
+	CreatedAt time.Time `json:"created_at"`
+	// The user the plan was built for, only this user can apply it
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name"`
+	// PlaylistID is the release playlist, empty when it does not exist yet
+	// and will be created with PlaylistName
+	PlaylistID   string `json:"playlist_id,omitempty"`
+	PlaylistName string `json:"playlist_name,omitempty"`
+	// SnapshotID is the version of the playlist the positions of the removed tracks are in
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	// Artists is the number of followed artists
+	Artists int `json:"artists"`
+	// FailedArtists are the artists whose albums could not be fetched
+	FailedArtists []string `json:"failed_artists,omitempty"`
+	// Albums are the new releases, with what happens to each of their tracks
+	Albums []*PlannedAlbum `json:"albums"`
+	// SkippedAlbums are the new releases left out, with the reason why
+	SkippedAlbums []*PlannedAlbum `json:"skipped_albums,omitempty"`
+	// Remove are the tracks of the playlist replaced by another version or too old
+	Remove []*PlannedTrack `json:"remove,omitempty"`
+}
+
+// PlannedAlbum : A new release and what happens to its tracks
+type PlannedAlbum struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Artists     []string `json:"artists"`
+	ReleaseDate string   `json:"release_date"`
+	// Reason tells why the album is skipped
+	Reason string          `json:"reason,omitempty"`
+	Tracks []*PlannedTrack `json:"tracks,omitempty"`
+}
+
+// PlannedTrack : A track added, skipped or removed from the playlist
+type PlannedTrack struct {
+	URI     string   `json:"uri"`
+	Name    string   `json:"name"`
+	Artists []string `json:"artists"`
+	// Album is set for the removed tracks, the others are listed under their album
+	Album  string `json:"album,omitempty"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	// Positions are where a removed track is in the playlist, counted from 0
+	Positions []int `json:"positions,omitempty"`
+	// ReplacedBy is the URI of the track added instead of a removed one, another version of the same song
+	ReplacedBy string `json:"replaced_by,omitempty"`
+}
+
+func newPlannedAlbum(a *models.SimplifiedAlbumObject, reason string) *PlannedAlbum {
+	return &PlannedAlbum{
+		ID:          a.ID,
+		Name:        a.Name,
+		Artists:     artistNames(a.Artists),
+		ReleaseDate: a.ReleaseDate.String(),
+		Reason:      reason,
+	}
+}
+
+func newPlannedTrack(t *models.Track, action, reason string) *PlannedTrack {
+	return &PlannedTrack{
+		URI:     t.URI,
+		Name:    t.Name,
+		Artists: artistNames(t.Artists),
+		Action:  action,
+		Reason:  reason,
+	}
+}
+
+func artistNames(artists []models.Artist) []string {
+	names := make([]string, 0, len(artists))
+	for _, a := range artists {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+// Complete : Reports whether the albums of every followed artist were looked at
+func (p *Plan) Complete() bool {
+	return len(p.FailedArtists) == 0
+}
+
+// TracksToAdd : Returns the URIs of the tracks to add, in order
+func (p *Plan) TracksToAdd() []string {
+	var uris []string
+	for _, a := range p.Albums {
+		for _, t := range a.Tracks {
+			if t.Action == ActionAdd {
+				uris = append(uris, t.URI)
+			}
+		}
+	}
+	return uris
+}
+
+// TracksToRemove : Returns the tracks to remove from the playlist, at their positions in the version SnapshotID.
+// The tracks replaced by one of notAdded are left out, so a song is never removed without its new version.
+func (p *Plan) TracksToRemove(notAdded map[string]bool) []models.TrackPositions {
+	tracks := make([]models.TrackPositions, 0, len(p.Remove))
+	for _, t := range p.Remove {
+		if t.ReplacedBy == "" || !notAdded[t.ReplacedBy] {
+			tracks = append(tracks, models.TrackPositions{URI: t.URI, Positions: t.Positions})
+		}
+	}
+	return tracks
+}
+
+// removals returns the number of playlist items the tracks are removed from
+func removals(tracks []models.TrackPositions) int {
+	n := 0
+	for _, t := range tracks {
+		n += len(t.Positions)
+	}
+	return n
+}
+
+// Write : Prints the plan in the format, FormatTable or FormatJSON
+func (p *Plan) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	case FormatTable:
+		return p.writeTable(w)
+	default:
+		return fmt.Errorf("unknown plan format %q, expected %q or %q", format, FormatTable, FormatJSON)
+	}
+}
+
+func (p *Plan) writeTable(w io.Writer) error {
+	playlist := p.PlaylistID
+	if playlist == "" {
+		playlist = fmt.Sprintf("%q (to be created)", p.PlaylistName)
+	}
+	fmt.Fprintf(w, "Plan for %s, playlist %s: %d tracks to add, %d to remove\n",
+		p.UserName, playlist, len(p.TracksToAdd()), removals(p.TracksToRemove(nil)))
+	for _, a := range p.FailedArtists {
+		fmt.Fprintf(w, "Albums not fetched for %s\n", a)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ARTIST\tALBUM\tRELEASED\tTRACK\tACTION\tREASON")
+	for _, a := range p.Albums {
+		for _, t := range a.Tracks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				strings.Join(a.Artists, ", "), a.Name, a.ReleaseDate, t.Name, t.Action, t.Reason)
+		}
+	}
+	for _, a := range p.SkippedAlbums {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			strings.Join(a.Artists, ", "), a.Name, a.ReleaseDate, "-", ActionSkip, a.Reason)
+	}
+	for _, t := range p.Remove {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			strings.Join(t.Artists, ", "), t.Album, "", t.Name, t.Action, t.Reason)
+	}
+	return tw.Flush()
+}
+
+// Save : Writes the plan as JSON so it can be applied with LoadPlan
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadPlan : Reads a plan saved with Save
+func LoadPlan(path string) (*Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &p, nil
+}
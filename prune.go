@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+// What the age of a playlist track is computed from
+const (
+	PruneByRelease = "release"
+	PruneByAdded   = "added"
+)
+
+// ExpiredTracks : Returns the positions in the playlist of the items older than maxAgeDays, the ones to remove
+// so the playlist only holds the releases of the last N days. The age is taken from the album release date
+// (PruneByRelease) or from the time the track was added (PruneByAdded). Tracks without a usable release date
+// fall back to the time they were added, and are kept if that is unknown too.
+func ExpiredTracks(items []models.PlaylistTrack, maxAgeDays int, by string, now time.Time) ([]int, error) {
+	if by != PruneByRelease && by != PruneByAdded {
+		return nil, fmt.Errorf("invalid prune mode %q, expected %q or %q", by, PruneByRelease, PruneByAdded)
+	}
+	cutoff := now.AddDate(0, 0, -maxAgeDays)
+
+	var expired []int
+	for i, item := range items {
+		// Unavailable tracks have no URI to remove them with
+		if item.Track == nil || item.Track.URI == "" {
+			continue
+		}
+		if isExpired(item, by, cutoff) {
+			expired = append(expired, i)
+		}
+	}
+	return expired, nil
+}
+
+// isExpired reports whether the playlist item is older than the cutoff
+func isExpired(item models.PlaylistTrack, by string, cutoff time.Time) bool {
+	if by == PruneByRelease && item.Track.Album != nil && item.Track.Album.ReleaseDate.Valid() {
+		// Dated to the last day of its release period like in the release window,
+		// an album dated "2020" expires at the end of 2020
+		return item.Track.Album.ReleaseDate.LastDay().Before(cutoff)
+	}
+	addedAt, err := time.Parse(models.TimestampLayout, item.AddedAt)
+	if err != nil {
+		return false
+	}
+	return addedAt.Before(cutoff)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+func TestExpiredTracks(t *testing.T) {
+	now := time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)
+	item := func(uri, released, added string) models.PlaylistTrack {
+		d, _ := models.ParseReleaseDate(released)
+		return models.PlaylistTrack{
+			AddedAt: added,
+			Track:   &models.Track{URI: uri, Album: &models.SimplifiedAlbumObject{ReleaseDate: d}},
+		}
+	}
+	items := []models.PlaylistTrack{
+		item("spotify:track:old", "2020-05-01", "2020-06-29T00:00:00Z"),
+		item("spotify:track:new", "2020-06-20", "2020-05-01T00:00:00Z"),
+		// The same track twice, both occurrences expire
+		item("spotify:track:old", "2020-05-01", "2020-06-29T00:00:00Z"),
+		// Dated to the last day of May
+		item("spotify:track:month", "2020-05", "2020-06-29T00:00:00Z"),
+		// No release date, the time it was added is used
+		item("spotify:track:undated", "", "2020-05-01T00:00:00Z"),
+		item("spotify:track:unknown", "", ""),
+		{AddedAt: "2020-05-01T00:00:00Z"},
+	}
+	tests := []struct {
+		by      string
+		days    int
+		want    []int
+		wantErr bool
+	}{
+		{by: PruneByRelease, days: 30, want: []int{0, 2, 3, 4}},
+		{by: PruneByRelease, days: 45, want: []int{0, 2, 4}},
+		{by: PruneByAdded, days: 30, want: []int{1, 4}},
+		{by: PruneByAdded, days: 90, want: nil},
+		{by: "played", days: 30, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ExpiredTracks(items, tt.days, tt.by, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %d days: error = %v, want error %v", tt.by, tt.days, err, tt.wantErr)
+			continue
+		}
+		if !equalInts(got, tt.want) {
+			t.Errorf("%s %d days: ExpiredTracks = %v, want %v", tt.by, tt.days, got, tt.want)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+// Album groups, i.e. the relationship between an artist and an album
+const (
+	GroupAlbum       = "album"
+	GroupSingle      = "single"
+	GroupAppearsOn   = "appears_on"
+	GroupCompilation = "compilation"
+)
+
+// Album types, i.e. what kind of release the album is whatever the artist's part in it
+const (
+	TypeAlbum       = "album"
+	TypeSingle      = "single"
+	TypeCompilation = "compilation"
+)
+
+// How explicit tracks are handled
+const (
+	ExplicitAllow   = "allow"
+	ExplicitExclude = "exclude"
+	ExplicitOnly    = "only"
+)
+
+// ReleaseRules : Rules deciding which releases make it to the playlist.
+// They are read from a JSON file, for example
+//
+//	{"include_groups": ["album", "single"], "exclude_types": ["compilation"], "min_tracks": 2, "explicit": "exclude", "require_market": true}
+type ReleaseRules struct {
+	// IncludeGroups are the album groups fetched for each artist, album and single if empty
+	IncludeGroups []string `json:"include_groups"`
+	// ExcludeGroups are album groups dropped even if they are included
+	ExcludeGroups []string `json:"exclude_groups"`
+	// IncludeTypes keeps only the albums of these types, every type if empty.
+	// Unlike the groups, the type of a single is "single" whichever artist it is fetched for.
+	IncludeTypes []string `json:"include_types"`
+	// ExcludeTypes are album types dropped even if they are included
+	ExcludeTypes []string `json:"exclude_types"`
+	// MinTracks drops the albums with fewer tracks, 0 keeps everything
+	MinTracks int `json:"min_tracks"`
+	// Explicit is one of "allow" (default), "exclude" or "only"
+	Explicit string `json:"explicit"`
+	// RequireMarket drops the albums and tracks not available in the user's country
+	RequireMarket bool `json:"require_market"`
+}
+
+// DefaultReleaseRules : The rules used without a rules file, albums and singles with no other filter
+func DefaultReleaseRules() ReleaseRules {
+	return ReleaseRules{
+		IncludeGroups: []string{GroupAlbum, GroupSingle},
+		Explicit:      ExplicitAllow,
+	}
+}
+
+// LoadReleaseRules : Reads the rules from a JSON file, missing fields keep their default value
+func LoadReleaseRules(path string) (ReleaseRules, error) {
+	rules := DefaultReleaseRules()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := rules.Validate(); err != nil {
+		return rules, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// Validate : Checks that the groups, types and explicit setting are known values
+func (r ReleaseRules) Validate() error {
+	for _, groups := range [][]string{r.IncludeGroups, r.ExcludeGroups} {
+		for _, g := range groups {
+			switch g {
+			case GroupAlbum, GroupSingle, GroupAppearsOn, GroupCompilation:
+			default:
+				return fmt.Errorf("unknown album group %q", g)
+			}
+		}
+	}
+	for _, types := range [][]string{r.IncludeTypes, r.ExcludeTypes} {
+		for _, t := range types {
+			switch t {
+			case TypeAlbum, TypeSingle, TypeCompilation:
+			default:
+				return fmt.Errorf("unknown album type %q", t)
+			}
+		}
+	}
+	switch r.Explicit {
+	case "", ExplicitAllow, ExplicitExclude, ExplicitOnly:
+	default:
+		return fmt.Errorf("invalid explicit setting %q, expected %q, %q or %q", r.Explicit, ExplicitAllow, ExplicitExclude, ExplicitOnly)
+	}
+	if len(r.Groups()) == 0 {
+		return fmt.Errorf("every album group is excluded")
+	}
+	if len(r.IncludeTypes) > 0 && !r.allowType(r.IncludeTypes...) {
+		return fmt.Errorf("every album type is excluded")
+	}
+	return nil
+}
+
+// Groups : Returns the album groups to request for each artist
+func (r ReleaseRules) Groups() []string {
+	include := r.IncludeGroups
+	if len(include) == 0 {
+		include = DefaultReleaseRules().IncludeGroups
+	}
+	var groups []string
+	for _, g := range include {
+		if !contains(r.ExcludeGroups, g) {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// AllowAlbum : Reports whether the album passes the rules for a user in country.
+// When it does not, the second value tells why.
+func (r ReleaseRules) AllowAlbum(a *models.SimplifiedAlbumObject, country string) (bool, string) {
+	// The API returns the group the album was requested for, drop the excluded ones
+	// in case an album shows up through another artist
+	if a.AlbumGroup != "" && !contains(r.Groups(), a.AlbumGroup) {
+		return false, "album group " + a.AlbumGroup + " excluded"
+	}
+	if a.AlbumType != "" && !r.allowType(a.AlbumType) {
+		return false, "album type " + a.AlbumType + " excluded"
+	}
+	if r.MinTracks > 0 && a.TotalTracks < r.MinTracks {
+		return false, fmt.Sprintf("%d tracks, less than %d", a.TotalTracks, r.MinTracks)
+	}
+	if !r.availableIn(a.AvailableMarkets, country) {
+		return false, "not available in " + country
+	}
+	return true, ""
+}
+
+// AllowTrack : Reports whether the track passes the rules for a user in country.
+// When it does not, the second value tells why.
+func (r ReleaseRules) AllowTrack(t *models.Track, country string) (bool, string) {
+	switch {
+	case r.Explicit == ExplicitExclude && t.Explicit:
+		return false, "explicit"
+	case r.Explicit == ExplicitOnly && !t.Explicit:
+		return false, "not explicit"
+	}
+	if !r.availableIn(t.AvailableMarkets, country) {
+		return false, "not available in " + country
+	}
+	return true, ""
+}
+
+// allowType reports whether one of the album types is included and not excluded
+func (r ReleaseRules) allowType(types ...string) bool {
+	for _, t := range types {
+		if (len(r.IncludeTypes) == 0 || contains(r.IncludeTypes, t)) && !contains(r.ExcludeTypes, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// availableIn reports whether country is in markets when the market rule is enabled.
+// An empty market list means the API did not tell, it is not held against the release.
+func (r ReleaseRules) availableIn(markets []string, country string) bool {
+	if !r.RequireMarket || country == "" || len(markets) == 0 {
+		return true
+	}
+	return contains(markets, country)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+func TestReleaseRulesValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   ReleaseRules
+		wantErr bool
+	}{
+		{"default", DefaultReleaseRules(), false},
+		{"empty", ReleaseRules{}, false},
+		{"types", ReleaseRules{IncludeTypes: []string{TypeAlbum}, ExcludeTypes: []string{TypeCompilation}}, false},
+		{"unknown group", ReleaseRules{IncludeGroups: []string{"ep"}}, true},
+		{"unknown type", ReleaseRules{ExcludeTypes: []string{"appears_on"}}, true},
+		{"unknown explicit", ReleaseRules{Explicit: "never"}, true},
+		{"every group excluded", ReleaseRules{IncludeGroups: []string{GroupSingle}, ExcludeGroups: []string{GroupSingle}}, true},
+		{"every type excluded", ReleaseRules{IncludeTypes: []string{TypeSingle}, ExcludeTypes: []string{TypeSingle}}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.rules.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReleaseRulesGroups(t *testing.T) {
+	tests := []struct {
+		rules ReleaseRules
+		want  []string
+	}{
+		{ReleaseRules{}, []string{GroupAlbum, GroupSingle}},
+		{ReleaseRules{IncludeGroups: []string{GroupAppearsOn, GroupSingle}}, []string{GroupAppearsOn, GroupSingle}},
+		{ReleaseRules{ExcludeGroups: []string{GroupSingle}}, []string{GroupAlbum}},
+	}
+	for _, tt := range tests {
+		if got := tt.rules.Groups(); !equalStrings(got, tt.want) {
+			t.Errorf("%+v: Groups() = %v, want %v", tt.rules, got, tt.want)
+		}
+	}
+}
+
+func TestReleaseRulesAllowAlbum(t *testing.T) {
+	album := func(group, albumType string, tracks int, markets ...string) *models.SimplifiedAlbumObject {
+		return &models.SimplifiedAlbumObject{AlbumGroup: group, AlbumType: albumType, TotalTracks: tracks, AvailableMarkets: markets}
+	}
+	tests := []struct {
+		name  string
+		rules ReleaseRules
+		album *models.SimplifiedAlbumObject
+		want  bool
+	}{
+		{"album", DefaultReleaseRules(), album(GroupAlbum, TypeAlbum, 10), true},
+		{"group not requested", DefaultReleaseRules(), album(GroupAppearsOn, TypeAlbum, 10), false},
+		{"excluded group", ReleaseRules{ExcludeGroups: []string{GroupSingle}}, album(GroupSingle, TypeSingle, 1), false},
+		// A compilation the artist appears on, requested as appears_on
+		{"excluded type", ReleaseRules{IncludeGroups: []string{GroupAppearsOn}, ExcludeTypes: []string{TypeCompilation}}, album(GroupAppearsOn, TypeCompilation, 20), false},
+		{"other type", ReleaseRules{IncludeGroups: []string{GroupAppearsOn}, ExcludeTypes: []string{TypeCompilation}}, album(GroupAppearsOn, TypeSingle, 1), true},
+		{"included type", ReleaseRules{IncludeTypes: []string{TypeAlbum}}, album(GroupAlbum, TypeAlbum, 10), true},
+		{"type not included", ReleaseRules{IncludeTypes: []string{TypeAlbum}}, album(GroupSingle, TypeSingle, 1), false},
+		{"unknown type", ReleaseRules{IncludeTypes: []string{TypeAlbum}}, album(GroupAlbum, "", 10), true},
+		{"too few tracks", ReleaseRules{MinTracks: 3}, album(GroupSingle, TypeSingle, 2), false},
+		{"enough tracks", ReleaseRules{MinTracks: 3}, album(GroupSingle, TypeSingle, 3), true},
+		{"other market", ReleaseRules{RequireMarket: true}, album(GroupAlbum, TypeAlbum, 10, "US"), false},
+		{"market", ReleaseRules{RequireMarket: true}, album(GroupAlbum, TypeAlbum, 10, "US", "CA"), true},
+		{"no market given", ReleaseRules{RequireMarket: true}, album(GroupAlbum, TypeAlbum, 10), true},
+		{"market not required", ReleaseRules{}, album(GroupAlbum, TypeAlbum, 10, "US"), true},
+	}
+	for _, tt := range tests {
+		got, reason := tt.rules.AllowAlbum(tt.album, "CA")
+		if got != tt.want {
+			t.Errorf("%s: AllowAlbum = %v (%s), want %v", tt.name, got, reason, tt.want)
+		}
+		if !got && reason == "" {
+			t.Errorf("%s: AllowAlbum gave no reason", tt.name)
+		}
+	}
+}
+
+func TestReleaseRulesAllowTrack(t *testing.T) {
+	clean := &models.Track{AvailableMarkets: []string{"CA"}}
+	explicit := &models.Track{Explicit: true}
+	tests := []struct {
+		rules ReleaseRules
+		track *models.Track
+		want  bool
+	}{
+		{ReleaseRules{Explicit: ExplicitAllow}, explicit, true},
+		{ReleaseRules{Explicit: ExplicitExclude}, explicit, false},
+		{ReleaseRules{Explicit: ExplicitExclude}, clean, true},
+		{ReleaseRules{Explicit: ExplicitOnly}, clean, false},
+		{ReleaseRules{Explicit: ExplicitOnly}, explicit, true},
+		{ReleaseRules{RequireMarket: true}, clean, true},
+		{ReleaseRules{RequireMarket: true}, &models.Track{AvailableMarkets: []string{"FR"}}, false},
+	}
+	for i, tt := range tests {
+		if got, reason := tt.rules.AllowTrack(tt.track, "CA"); got != tt.want {
+			t.Errorf("case %d: AllowTrack = %v (%s), want %v", i, got, reason, tt.want)
+		}
+	}
+}
+
+func TestLoadReleaseRules(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    ReleaseRules
+		wantErr bool
+	}{
+		{json: `{}`, want: DefaultReleaseRules()},
+		{json: `{"exclude_types": ["compilation"], "min_tracks": 2}`, want: ReleaseRules{
+			IncludeGroups: []string{GroupAlbum, GroupSingle}, ExcludeTypes: []string{TypeCompilation}, MinTracks: 2, Explicit: ExplicitAllow,
+		}},
+		{json: `{"include_types": ["ep"]}`, wantErr: true},
+		{json: `{"min_tracks": "2"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		if err := os.WriteFile(path, []byte(tt.json), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadReleaseRules(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.json, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !equalStrings(got.IncludeGroups, tt.want.IncludeGroups) || !equalStrings(got.ExcludeTypes, tt.want.ExcludeTypes) ||
+			got.MinTracks != tt.want.MinTracks || got.Explicit != tt.want.Explicit {
+			t.Errorf("%s: LoadReleaseRules = %+v, want %+v", tt.json, got, tt.want)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule : Tells when the next run of a recurring job is due
+type Schedule interface {
+	// Next returns the first run time strictly after t
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule : Parses a schedule given as
+// "fri 06:00" for every Friday at 06:00, "daily 06:00" for every day at 06:00
+// or "@every 12h" for a fixed interval. Times are in the local time zone.
+func ParseSchedule(s string) (Schedule, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid schedule %q: expected \"<day> HH:MM\" or \"@every <duration>\"", s)
+	}
+
+	if fields[0] == "@every" {
+		d, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", s, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: interval must be positive", s)
+		}
+		return intervalSchedule(d), nil
+	}
+
+	at, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: expected a HH:MM time", s)
+	}
+	sched := &weeklySchedule{hour: at.Hour(), minute: at.Minute()}
+	if fields[0] == "daily" {
+		return sched, nil
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if fields[0] == name || fields[0] == name[:3] {
+			sched.weekday = &d
+			return sched, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid schedule %q: unknown day %q", s, fields[0])
+}
+
+// intervalSchedule runs the job at a fixed interval
+type intervalSchedule time.Duration
+
+func (i intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// weeklySchedule runs the job at a fixed time of day, every day or on a single weekday
+type weeklySchedule struct {
+	weekday      *time.Weekday
+	hour, minute int
+}
+
+func (w *weeklySchedule) Next(t time.Time) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day(), w.hour, w.minute, 0, 0, t.Location())
+	for !next.After(t) || (w.weekday != nil && next.Weekday() != *w.weekday) {
+		// AddDate keeps the wall clock time across daylight saving changes
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2020, 6, 3, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "fri 06:00", want: time.Date(2020, 6, 5, 6, 0, 0, 0, time.UTC)},
+		{in: "Friday 06:00", want: time.Date(2020, 6, 5, 6, 0, 0, 0, time.UTC)},
+		{in: "wed 11:00", want: time.Date(2020, 6, 3, 11, 0, 0, 0, time.UTC)},
+		// Already past today, next week
+		{in: "wed 09:00", want: time.Date(2020, 6, 10, 9, 0, 0, 0, time.UTC)},
+		{in: "wed 10:00", want: time.Date(2020, 6, 10, 10, 0, 0, 0, time.UTC)},
+		{in: "daily 23:30", want: time.Date(2020, 6, 3, 23, 30, 0, 0, time.UTC)},
+		{in: "daily 06:00", want: time.Date(2020, 6, 4, 6, 0, 0, 0, time.UTC)},
+		{in: "@every 12h", want: now.Add(12 * time.Hour)},
+		{in: "@every 90m", want: now.Add(90 * time.Minute)},
+		{in: "someday 06:00", wantErr: true},
+		{in: "fri 25:00", wantErr: true},
+		{in: "fri 6am", wantErr: true},
+		{in: "fri", wantErr: true},
+		{in: "@every soon", wantErr: true},
+		{in: "@every -1h", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSchedule(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got := s.Next(now); !got.Equal(tt.want) {
+			t.Errorf("ParseSchedule(%q).Next(%s) = %s, want %s", tt.in, now, got, tt.want)
+		}
+	}
+}
+
+func TestWeeklyScheduleKeepsWallClock(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	s, err := ParseSchedule("sun 06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clocks go forward on the night of Sunday March 29 2020
+	got := s.Next(time.Date(2020, 3, 22, 7, 0, 0, 0, loc))
+	if want := time.Date(2020, 3, 29, 6, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"net/http"
+	"net/url"
 	"os"
 
 	"github.com/Kozehh/SpotifyFunc/spotify/models"
@@ -25,13 +26,23 @@ const (
 type Authenticator struct {
 	config  *oauth2.Config
 	context context.Context
+	// baseURL is the address of the Web API given to the clients
+	baseURL string
+	// pkce is set for the Authorization Code with PKCE flow, which has no client secret
+	pkce bool
+	// logins are the logins started by StartLogin, shared by the copies of the authenticator
+	logins *pendingLogins
 }
 
 // NewAuthenticator : Returns new spotify authentificator
 func NewAuthenticator(redirectURL string, scopes ...string) Authenticator {
+	return newAuthenticator(os.Getenv("SPOTIFY_SECRET"), redirectURL, scopes)
+}
+
+func newAuthenticator(secret, redirectURL string, scopes []string) Authenticator {
 	cfg := &oauth2.Config{
 		ClientID:     os.Getenv("SPOTIFY_ID"),
-		ClientSecret: os.Getenv("SPOTIFY_SECRET"),
+		ClientSecret: secret,
 		RedirectURL:  redirectURL,
 		Scopes:       scopes,
 		Endpoint: oauth2.Endpoint{
@@ -48,9 +59,22 @@ func NewAuthenticator(redirectURL string, scopes ...string) Authenticator {
 	return Authenticator{
 		config:  cfg,
 		context: ctx,
+		baseURL: models.BaseAddress,
+		logins:  &pendingLogins{logins: map[string]*Login{}},
 	}
 }
 
+// WithEndpoints : Returns a copy of the authenticator using other Accounts service endpoints
+// and Web API address, like the ones of a spotifytest.Server
+func (a Authenticator) WithEndpoints(authURL, tokenURL, baseURL string) Authenticator {
+	cfg := *a.config
+	cfg.Endpoint.AuthURL = authURL
+	cfg.Endpoint.TokenURL = tokenURL
+	a.config = &cfg
+	a.baseURL = baseURL
+	return a
+}
+
 // AuthURL : Calls OAuth2 method 'AuthCodeURL' with the current authenticator configs
 // Returns: A URL to OAuth 2.0 provider's consent page
 // that asks for permissions for the required scopes explicitly.
@@ -58,32 +82,47 @@ func (a Authenticator) AuthURL(state string) string {
 	return a.config.AuthCodeURL(state)
 }
 
-// Token : Maps the internal token in the authenticator context with the OAuth2 token
+// Token : Maps the internal token in the authenticator context with the OAuth2 token.
+// The redirect must carry the state, and the state must be the one of a login started by StartLogin:
+// like CompleteLogin, it is consumed so it can't be replayed, and a PKCE login uses its verifier.
 func (a Authenticator) Token(state string, req *http.Request) (*oauth2.Token, error) {
 	values := req.URL.Query()
+	if values.Get("state") != state {
+		return nil, errors.New("spotify: redirect state parameter doesn't match")
+	}
+	return a.completeLogin(values)
+}
+
+// authCode returns the authorization code of the query of the redirect, checking its state
+func authCode(state string, values url.Values) (string, error) {
 	if e := values.Get("error"); e != "" {
-		return nil, errors.New("spotify: auth failed - " + e)
+		return "", errors.New("spotify: auth failed - " + e)
 	}
 	code := values.Get("code")
 	if code == "" {
-		return nil, errors.New("spotify: didn't get access code")
+		return "", errors.New("spotify: didn't get access code")
 	}
 	actualState := values.Get("state")
 	if actualState != state {
-		return nil, errors.New("spotify: redirect state parameter doesn't match")
+		return "", errors.New("spotify: redirect state parameter doesn't match")
 	}
-
-	// if there was no errors or mismatches, (link/refresh/map) the internal token with the OAuth Token
-	return a.config.Exchange(a.context, code)
+	return code, nil
 }
 
 // NewClient : Creates a Client that will use the specified access token for its API requests.
-func (a Authenticator) NewClient(token *oauth2.Token) models.Client {
+// The requests go through the middlewares in order, before the token is added to them.
+func (a Authenticator) NewClient(token *oauth2.Token, middlewares ...models.Middleware) models.Client {
+	return a.newClient(a.config.TokenSource(a.context, token), middlewares)
+}
+
+func (a Authenticator) newClient(source oauth2.TokenSource, middlewares []models.Middleware) models.Client {
 	// Create a new http client using the token and current context
-	client := a.config.Client(a.context, token)
+	client := oauth2.NewClient(a.context, source)
 	// The app client object is now the new one created
-	return models.Client{
+	c := models.Client{
 		Http:    client,
-		BaseURL: models.BaseAddress,
+		BaseURL: a.baseURL,
 	}
+	c.Use(middlewares...)
+	return c
 }
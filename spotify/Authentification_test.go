@@ -0,0 +1,43 @@
+package spotify_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+	"golang.org/x/oauth2"
+)
+
+func TestNewClientMiddlewares(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	auth := s.Authenticator(redirectURI)
+
+	var seen []http.Header
+	saw := func(next http.RoundTripper) http.RoundTripper {
+		return models.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			seen = append(seen, req.Header.Clone())
+			return next.RoundTrip(req)
+		})
+	}
+	c := auth.NewClient(&oauth2.Token{AccessToken: s.Token(), TokenType: "Bearer"}, models.WithUserAgent("SpotifyFunc"), saw)
+
+	user, err := c.CurrentUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "spotifytest" {
+		t.Errorf("logged in as %q, want spotifytest", user.ID)
+	}
+	if len(seen) != 1 {
+		t.Fatalf("the middleware saw %d requests, want 1", len(seen))
+	}
+	// The middlewares run in order, before the token is added
+	if got := seen[0].Get("User-Agent"); got != "SpotifyFunc" {
+		t.Errorf("User-Agent = %q, want the one of the middleware before", got)
+	}
+	if got := seen[0].Get("Authorization"); got != "" {
+		t.Errorf("the middleware saw the Authorization header %q, want none", got)
+	}
+}
@@ -0,0 +1,28 @@
+package spotify
+
+import (
+	"errors"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// NewAppClient : Creates a Client with an app-only token from the client credentials grant, for the jobs
+// that only read the catalogue, like artist albums and album tracks, without a user having to log in.
+// The token is requested again when it expires. The user endpoints, like CurrentUser or the playlist
+// modifications, fail with models.ErrUserRequired.
+// The client secret is required, the PKCE authenticators can't make app clients.
+func (a Authenticator) NewAppClient(middlewares ...models.Middleware) (models.Client, error) {
+	if a.config.ClientSecret == "" {
+		return models.Client{}, errors.New("spotify: the client credentials flow needs SPOTIFY_SECRET")
+	}
+	cfg := clientcredentials.Config{
+		ClientID:     a.config.ClientID,
+		ClientSecret: a.config.ClientSecret,
+		TokenURL:     a.config.Endpoint.TokenURL,
+		AuthStyle:    a.config.Endpoint.AuthStyle,
+	}
+	c := a.newClient(cfg.TokenSource(a.context), middlewares)
+	c.AppOnly = true
+	return c, nil
+}
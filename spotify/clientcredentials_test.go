@@ -0,0 +1,73 @@
+package spotify_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Kozehh/SpotifyFunc/spotify"
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+func TestNewAppClientWithoutSecret(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	t.Setenv("SPOTIFY_SECRET", "")
+
+	for _, auth := range []spotify.Authenticator{newAuthenticator(s, false), newAuthenticator(s, true)} {
+		if _, err := auth.NewAppClient(); err == nil {
+			t.Error("NewAppClient() without a client secret succeeded")
+		}
+	}
+}
+
+func TestNewAppClient(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	t.Setenv("SPOTIFY_SECRET", "secret")
+	artist := s.FollowArtist(models.Artist{Name: "Artist"})
+	s.AddAlbum(artist, models.SimplifiedAlbumObject{Name: "Album"}, models.Track{Name: "Song"})
+	playlistID := s.AddPlaylist("Playlist")
+
+	c, err := s.Authenticator(redirectURI).NewAppClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The catalogue is read with the token of the client credentials grant
+	albums, err := c.GetArtistAlbums(artist.ID, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(albums) != 1 || albums[0].Name != "Album" {
+		t.Fatalf("GetArtistAlbums() = %v, want Album", albums)
+	}
+	tracks, err := c.GetAlbumTracks(albums[0].ID, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 1 || tracks[0].Name != "Song" {
+		t.Errorf("GetAlbumTracks() = %v, want Song", tracks)
+	}
+
+	// The user endpoints fail without being sent
+	sent := s.Requests()
+	calls := []struct {
+		name string
+		call func() error
+	}{
+		{"CurrentUser", func() error { _, err := c.CurrentUser(); return err }},
+		{"GetFollowedArtists", func() error { _, err := c.GetFollowedArtists(50, ""); return err }},
+		{"AddTracksToPlaylist", func() error {
+			_, err := c.AddTracksToPlaylist(playlistID, []string{"spotify:track:b"})
+			return err
+		}},
+	}
+	for _, tt := range calls {
+		if err := tt.call(); !errors.Is(err, models.ErrUserRequired) {
+			t.Errorf("%s() error = %v, want ErrUserRequired", tt.name, err)
+		}
+	}
+	if s.Requests() != sent {
+		t.Errorf("%d requests sent for the user endpoints, want none", s.Requests()-sent)
+	}
+}
@@ -0,0 +1,169 @@
+package spotify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// LoginTimeout is how long the user has to accept the consent page of a login
+const LoginTimeout = 10 * time.Minute
+
+var (
+	// ErrUnknownState : The redirect has no state, or one not issued by StartLogin or already used
+	ErrUnknownState = errors.New("spotify: unknown or already used login state")
+	// ErrLoginExpired : The user did not come back from the consent page within LoginTimeout
+	ErrLoginExpired = errors.New("spotify: login expired")
+)
+
+// Login : A login started with StartLogin, waiting for the user to come back from the consent page
+type Login struct {
+	// URL is the consent page to send the user to
+	URL string
+	// State identifies the login in the redirect, it can only be used once
+	State   string
+	Expires time.Time
+
+	verifier string
+	// done receives the result of the login once, it is buffered so the callback never blocks
+	done chan loginResult
+}
+
+type loginResult struct {
+	token *oauth2.Token
+	err   error
+}
+
+// pendingLogins are the logins waiting for their redirect, by state
+type pendingLogins struct {
+	mu     sync.Mutex
+	logins map[string]*Login
+}
+
+// Wait : Waits for the user to log in, returning the token obtained by CompleteLogin.
+// Fails with ErrLoginExpired if the user did not come back in time.
+func (l *Login) Wait(ctx context.Context) (*oauth2.Token, error) {
+	timer := time.NewTimer(time.Until(l.Expires))
+	defer timer.Stop()
+	select {
+	case res := <-l.done:
+		return res.token, res.err
+	case <-timer.C:
+		return nil, ErrLoginExpired
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// StartLogin : Starts a login with a new random state, and a code verifier for the PKCE authenticators.
+// Several logins can be in progress at once, each one is completed by CompleteLogin.
+func (a Authenticator) StartLogin() (*Login, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	l := &Login{
+		State:   state,
+		Expires: time.Now().Add(LoginTimeout),
+		done:    make(chan loginResult, 1),
+	}
+	if a.pkce {
+		if l.verifier, err = generateVerifier(); err != nil {
+			return nil, err
+		}
+		l.URL = a.authURLWithVerifier(state, l.verifier)
+	} else {
+		l.URL = a.AuthURL(state)
+	}
+
+	a.logins.mu.Lock()
+	defer a.logins.mu.Unlock()
+	a.logins.removeExpired(time.Now())
+	a.logins.logins[state] = l
+	return l, nil
+}
+
+// CompleteLogin : Handles the redirect of a login started by StartLogin. Its state is checked and
+// consumed, so it can't be replayed, then the code is exchanged for the token which is also given
+// to the Wait of the login. Returns ErrUnknownState for a redirect matching no pending login.
+func (a Authenticator) CompleteLogin(req *http.Request) (*oauth2.Token, error) {
+	return a.completeLogin(req.URL.Query())
+}
+
+// CompleteLoginFrom : Completes the login from what the user pasted, for the machines whose browser
+// can't reach the redirect URI: the full address of the page the consent page sent them to,
+// or only the code it holds. See CompleteLogin.
+func (a Authenticator) CompleteLoginFrom(l *Login, pasted string) (*oauth2.Token, error) {
+	pasted = strings.TrimSpace(pasted)
+	if pasted == "" {
+		return nil, errors.New("spotify: nothing was pasted")
+	}
+	values := url.Values{}
+	if strings.Contains(pasted, "?") {
+		u, err := url.Parse(pasted)
+		if err != nil {
+			return nil, err
+		}
+		values = u.Query()
+		// The pasted address must be the redirect of this login
+		if values.Get("state") != l.State {
+			return nil, ErrUnknownState
+		}
+	} else {
+		values.Set("code", pasted)
+		values.Set("state", l.State)
+	}
+	return a.completeLogin(values)
+}
+
+// completeLogin exchanges the code of the redirect query for the token of its pending login
+func (a Authenticator) completeLogin(values url.Values) (*oauth2.Token, error) {
+	state := values.Get("state")
+
+	a.logins.mu.Lock()
+	a.logins.removeExpired(time.Now())
+	l, ok := a.logins.logins[state]
+	delete(a.logins.logins, state)
+	a.logins.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownState
+	}
+
+	var token *oauth2.Token
+	code, err := authCode(state, values)
+	if err == nil {
+		var opts []oauth2.AuthCodeOption
+		if l.verifier != "" {
+			opts = append(opts, oauth2.SetAuthURLParam("code_verifier", l.verifier))
+		}
+		token, err = a.config.Exchange(a.context, code, opts...)
+	}
+	l.done <- loginResult{token: token, err: err}
+	return token, err
+}
+
+// removeExpired forgets the logins whose user did not come back in time, p.mu must be held
+func (p *pendingLogins) removeExpired(now time.Time) {
+	for state, l := range p.logins {
+		if now.After(l.Expires) {
+			delete(p.logins, state)
+		}
+	}
+}
+
+// randomString returns n random bytes encoded for URLs
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,287 @@
+package spotify_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+const redirectURI = "http://localhost:8080/callback"
+
+// newAuthenticator returns an authenticator of the fake server, for the PKCE flow or not
+func newAuthenticator(s *spotifytest.Server, pkce bool) spotify.Authenticator {
+	if pkce {
+		return spotify.NewPKCEAuthenticator(redirectURI).WithEndpoints(s.AuthURL(), s.TokenURL(), s.URL())
+	}
+	return s.Authenticator(redirectURI)
+}
+
+// accept opens the consent page of the login and returns the redirect the user is sent back with
+func accept(t *testing.T, l *spotify.Login) *http.Request {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(l.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if location == "" {
+		t.Fatalf("consent page answered %s without redirecting", resp.Status)
+	}
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// redirectTo returns the redirect of the consent page with the query
+func redirectTo(t *testing.T, values url.Values) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("GET", redirectURI+"?"+values.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestCompleteLogin(t *testing.T) {
+	for _, pkce := range []bool{false, true} {
+		s := spotifytest.NewServer()
+		defer s.Close()
+		auth := newAuthenticator(s, pkce)
+
+		l, err := auth.StartLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(l.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := u.Query().Get("code_challenge") != ""; got != pkce {
+			t.Errorf("pkce %v: consent page has a code challenge: %v", pkce, got)
+		}
+		if u.Query().Get("state") != l.State {
+			t.Errorf("pkce %v: consent page state = %q, want %q", pkce, u.Query().Get("state"), l.State)
+		}
+
+		req := accept(t, l)
+		token, err := auth.CompleteLogin(req)
+		if err != nil {
+			t.Fatalf("pkce %v: %v", pkce, err)
+		}
+		if token.AccessToken != s.Token() {
+			t.Errorf("pkce %v: access token = %q, want %q", pkce, token.AccessToken, s.Token())
+		}
+		waited, err := l.Wait(context.Background())
+		if err != nil || waited != token {
+			t.Errorf("pkce %v: Wait = %v, %v, want the token of CompleteLogin", pkce, waited, err)
+		}
+
+		// The state was consumed, the same redirect can't be used twice
+		if _, err := auth.CompleteLogin(req); !errors.Is(err, spotify.ErrUnknownState) {
+			t.Errorf("pkce %v: replayed redirect: err = %v, want ErrUnknownState", pkce, err)
+		}
+	}
+}
+
+func TestCompleteLoginRoutesByState(t *testing.T) {
+	for _, pkce := range []bool{false, true} {
+		s := spotifytest.NewServer()
+		defer s.Close()
+		auth := newAuthenticator(s, pkce)
+
+		first, err := auth.StartLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := auth.StartLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.State == second.State {
+			t.Fatal("two logins have the same state")
+		}
+
+		// The second login comes back first, each one must be exchanged with its own verifier
+		for _, l := range []*spotify.Login{second, first} {
+			token, err := auth.CompleteLogin(accept(t, l))
+			if err != nil {
+				t.Fatalf("pkce %v: %v", pkce, err)
+			}
+			waited, err := l.Wait(context.Background())
+			if err != nil || waited != token {
+				t.Errorf("pkce %v: Wait = %v, %v, want the token of its own redirect", pkce, waited, err)
+			}
+		}
+	}
+}
+
+func TestCompleteLoginFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		pkce bool
+		// query returns the redirect query for the pending logins, the first one being the one to complete
+		query func(t *testing.T, logins []*spotify.Login) url.Values
+		want  error
+	}{
+		{
+			name: "no state",
+			query: func(t *testing.T, logins []*spotify.Login) url.Values {
+				return url.Values{"code": {spotifytest.Code}}
+			},
+			want: spotify.ErrUnknownState,
+		},
+		{
+			name: "state not issued by StartLogin",
+			query: func(t *testing.T, logins []*spotify.Login) url.Values {
+				return url.Values{"code": {spotifytest.Code}, "state": {"abc123"}}
+			},
+			want: spotify.ErrUnknownState,
+		},
+		{
+			name: "consent refused",
+			query: func(t *testing.T, logins []*spotify.Login) url.Values {
+				return url.Values{"error": {"access_denied"}, "state": {logins[0].State}}
+			},
+		},
+		{
+			name: "verifier of another login",
+			pkce: true,
+			query: func(t *testing.T, logins []*spotify.Login) url.Values {
+				// The consent page saw the challenge of the second login, but the code comes back to the first
+				q := accept(t, logins[1]).URL.Query()
+				q.Set("state", logins[0].State)
+				return q
+			},
+		},
+	}
+	for _, tt := range tests {
+		s := spotifytest.NewServer()
+		defer s.Close()
+		auth := newAuthenticator(s, tt.pkce)
+		var logins []*spotify.Login
+		for i := 0; i < 2; i++ {
+			l, err := auth.StartLogin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			logins = append(logins, l)
+		}
+
+		_, err := auth.CompleteLogin(redirectTo(t, tt.query(t, logins)))
+		if err == nil {
+			t.Errorf("%s: CompleteLogin succeeded", tt.name)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+		if tt.want == nil {
+			// The login was reached, its Wait gets the same failure
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, waitErr := logins[0].Wait(ctx)
+			cancel()
+			if waitErr == nil || waitErr == context.DeadlineExceeded {
+				t.Errorf("%s: Wait err = %v, want the error of CompleteLogin", tt.name, waitErr)
+			}
+		}
+	}
+}
+
+func TestCompleteLoginFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		// pasted returns what the user pastes for the login
+		pasted  func(t *testing.T, l *spotify.Login) string
+		wantErr bool
+	}{
+		{
+			name:   "redirect address",
+			pasted: func(t *testing.T, l *spotify.Login) string { return "  " + accept(t, l).URL.String() + "\n" },
+		},
+		{
+			name:   "code only",
+			pasted: func(t *testing.T, l *spotify.Login) string { return spotifytest.Code + "\n" },
+		},
+		{
+			name: "redirect of another login",
+			pasted: func(t *testing.T, l *spotify.Login) string {
+				return redirectURI + "?code=" + spotifytest.Code + "&state=other"
+			},
+			wantErr: true,
+		},
+		{
+			name:    "nothing",
+			pasted:  func(t *testing.T, l *spotify.Login) string { return "\n" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		s := spotifytest.NewServer()
+		defer s.Close()
+		auth := s.Authenticator(redirectURI)
+		l, err := auth.StartLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := auth.CompleteLoginFrom(l, tt.pasted(t, l))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: CompleteLoginFrom succeeded", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if token.AccessToken != s.Token() {
+			t.Errorf("%s: access token = %q, want %q", tt.name, token.AccessToken, s.Token())
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	for _, pkce := range []bool{false, true} {
+		s := spotifytest.NewServer()
+		defer s.Close()
+		auth := newAuthenticator(s, pkce)
+
+		// A state the authenticator did not issue is refused even when it matches the redirect
+		req := redirectTo(t, url.Values{"code": {spotifytest.Code}, "state": {"abc123"}})
+		if _, err := auth.Token("abc123", req); !errors.Is(err, spotify.ErrUnknownState) {
+			t.Errorf("pkce %v: state not issued: err = %v, want ErrUnknownState", pkce, err)
+		}
+
+		l, err := auth.StartLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = accept(t, l)
+		if _, err := auth.Token("other", req); err == nil {
+			t.Errorf("pkce %v: Token succeeded with another state", pkce)
+		}
+		token, err := auth.Token(l.State, req)
+		if err != nil {
+			t.Fatalf("pkce %v: %v", pkce, err)
+		}
+		if token.AccessToken != s.Token() {
+			t.Errorf("pkce %v: access token = %q, want %q", pkce, token.AccessToken, s.Token())
+		}
+		if _, err := auth.Token(l.State, req); !errors.Is(err, spotify.ErrUnknownState) {
+			t.Errorf("pkce %v: replayed redirect: err = %v, want ErrUnknownState", pkce, err)
+		}
+	}
+}
@@ -1,11 +1,122 @@
 package models
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// Precisions of a release date, as given by the 'release_date_precision' field
+const (
+	PrecisionYear  = "year"
+	PrecisionMonth = "month"
+	PrecisionDay   = "day"
+)
+
+// ReleaseDate : Release date of an album, known to the year, the month or the day.
+// Spotify sends "2006", "2006-01" or "2006-01-02" depending on the precision.
+type ReleaseDate struct {
+	// First day of the release period, at 00:00 UTC
+	Time time.Time
+	// One of PrecisionYear, PrecisionMonth or PrecisionDay, empty if the date could not be parsed
+	Precision string
+	// The date as returned by the API
+	Raw string
+}
+
+// releaseDateLayouts are the layouts of the release dates, by precision
+var releaseDateLayouts = map[string]string{
+	PrecisionDay:   DateLayout,
+	PrecisionMonth: "2006-01",
+	PrecisionYear:  "2006",
+}
+
+// ParseReleaseDate : Parses a Spotify release date, guessing its precision from its length.
+// Prefer ParseReleaseDatePrecision when the 'release_date_precision' field is known.
+func ParseReleaseDate(raw string) (ReleaseDate, error) {
+	var err error
+	for _, precision := range []string{PrecisionDay, PrecisionMonth, PrecisionYear} {
+		var d ReleaseDate
+		if d, err = ParseReleaseDatePrecision(raw, precision); err == nil {
+			return d, nil
+		}
+	}
+	return ReleaseDate{Raw: raw}, err
+}
+
+// ParseReleaseDatePrecision : Parses a Spotify release date of the given precision,
+// guessing it like ParseReleaseDate when the precision is empty
+func ParseReleaseDatePrecision(raw, precision string) (ReleaseDate, error) {
+	if precision == "" {
+		return ParseReleaseDate(raw)
+	}
+	layout, ok := releaseDateLayouts[precision]
+	if !ok {
+		return ReleaseDate{Raw: raw}, fmt.Errorf("unknown release date precision %q", precision)
+	}
+	t, err := time.Parse(layout, raw)
+	if err != nil {
+		return ReleaseDate{Raw: raw}, err
+	}
+	return ReleaseDate{Time: t, Precision: precision, Raw: raw}, nil
+}
+
+// Valid : Reports whether the date was successfully parsed
+func (d ReleaseDate) Valid() bool {
+	return d.Precision != ""
+}
+
+// End : Returns the first instant after the release period,
+// i.e. the next day, month or year depending on the precision
+func (d ReleaseDate) End() time.Time {
+	switch d.Precision {
+	case PrecisionYear:
+		return d.Time.AddDate(1, 0, 0)
+	case PrecisionMonth:
+		return d.Time.AddDate(0, 1, 0)
+	default:
+		return d.Time.AddDate(0, 0, 1)
+	}
+}
+
+// LastDay : Returns the last day of the release period. An album only known to be released
+// "2020-06" is dated June 30, the latest it can have been released, so it is never taken for
+// older than it is: this is the date the release window and the pruning go by.
+func (d ReleaseDate) LastDay() time.Time {
+	return d.End().AddDate(0, 0, -1)
+}
+
+// Within : Reports whether the last day of the release period is in [from, to), see LastDay
+func (d ReleaseDate) Within(from, to time.Time) bool {
+	last := d.LastDay()
+	return d.Valid() && !last.Before(from) && last.Before(to)
+}
+
+func (d ReleaseDate) String() string {
+	return d.Raw
+}
+
+// UnmarshalJSON : Parses the date, guessing its precision. SimplifiedAlbumObject parses it
+// again with its 'release_date_precision' field. A malformed date does not fail
+// the whole response, it is kept in Raw and reported by Valid.
+func (d *ReleaseDate) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*d, _ = ParseReleaseDate(raw)
+	return nil
+}
+
+// MarshalJSON : Writes the date back as received from the API
+func (d ReleaseDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Raw)
+}
+
 // SimplifiedAlbumObject : Is the full object returned by the API Endpoint '/v1/artists/{id}/albums'
 type SimplifiedAlbumObject struct {
 	// Compare to AlbumType this field represents relationship between the artist and the album
@@ -13,9 +124,10 @@ type SimplifiedAlbumObject struct {
 	// The type of the album: one of “album”, “single”, or “compilation”
 	AlbumType string `json:"album_type"`
 	// The artists of the album
-	Artists              []Artist `json:"artists"`
-	ReleaseDate          string   `json:"release_date"`
-	ReleaseDatePrecision string   `json:"release_date_precision"`
+	Artists []Artist `json:"artists"`
+	// The date the album was first released, see ReleaseDatePrecision for its accuracy
+	ReleaseDate          ReleaseDate `json:"release_date"`
+	ReleaseDatePrecision string      `json:"release_date_precision"`
 	// The markets in which the album is available
 	AvailableMarkets []string `json:"available_markets"`
 	// Known external URLs for this album
@@ -32,31 +144,53 @@ type SimplifiedAlbumObject struct {
 	Type string `json:"type"`
 	// Name of the album
 	Name string `json:"name"`
+	// The number of tracks in the album
+	TotalTracks int `json:"total_tracks"`
+}
+
+// UnmarshalJSON : Parses the album, with its release date read at the precision given by the API
+func (a *SimplifiedAlbumObject) UnmarshalJSON(data []byte) error {
+	// album has the fields but not the methods, so it is decoded the default way
+	type album SimplifiedAlbumObject
+	if err := json.Unmarshal(data, (*album)(a)); err != nil {
+		return err
+	}
+	if a.ReleaseDatePrecision != "" {
+		a.ReleaseDate, _ = ParseReleaseDatePrecision(a.ReleaseDate.Raw, a.ReleaseDatePrecision)
+	}
+	return nil
 }
 
 // ////////////////////////////////////////////////////////////////////////////// //
 // --------------------------------  FUNCTIONS  -------------------------------- //
 // //////////////////////////////////////////////////////////////////////////// //
 
-func (c *Client) GetAlbumTracks(albumID string, limit int) ([]*Track, error) {
-	funcURL := c.BaseURL + "albums/id/tracks"
-	funcURL = strings.Replace(funcURL, "id", albumID, -1)
+// GetAlbumTracks : Returns the tracks of the album, following the pages of the endpoint
+// Arg :
+// (1) - The Spotify ID of the album
+// (2) - The maximum number of tracks to return | *Put -1 to get them all
+func (c *Client) GetAlbumTracks(albumID string, max int) ([]*Track, error) {
+	return c.GetAlbumTracksContext(context.Background(), albumID, max)
+}
 
-	// Set query parameters
-	v := url.Values{}
-	v.Set("type", "artist")
+// GetAlbumTracksContext : Same as GetAlbumTracks, with a context to cancel the requests
+func (c *Client) GetAlbumTracksContext(ctx context.Context, albumID string, max int) ([]*Track, error) {
+	return c.iterAlbumTracks(ctx, albumID, max).All()
+}
 
-	if limit != -1 {
-		v.Set("limit", strconv.Itoa(limit))
-	}
+// IterAlbumTracks : Returns an iterator over the tracks of the album
+func (c *Client) IterAlbumTracks(ctx context.Context, albumID string) *Iterator[*Track] {
+	return c.iterAlbumTracks(ctx, albumID, -1)
+}
 
-	var res struct {
-		Tracks []*Track `json:"items"`
-	}
+func (c *Client) iterAlbumTracks(ctx context.Context, albumID string, max int) *Iterator[*Track] {
+	funcURL := c.BaseURL + "albums/{id}/tracks"
+	funcURL = strings.Replace(funcURL, "{id}", albumID, -1)
 
-	err := c.get(funcURL, &res)
-	if err != nil {
-		return nil, err
-	}
-	return res.Tracks, nil
+	// Set query parameters
+	v := url.Values{}
+	v.Set("limit", strconv.Itoa(pageLimit(50, max)))
+	funcURL += "?" + v.Encode()
+
+	return newIterator(ctx, funcURL, max, offsetPages[*Track](c))
 }
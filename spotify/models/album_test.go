@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAlbumReleaseDatePrecision(t *testing.T) {
+	tests := []struct {
+		json          string
+		wantPrecision string
+		wantTime      time.Time
+		wantValid     bool
+	}{
+		{`{"release_date":"2020-06-15","release_date_precision":"day"}`, PrecisionDay, time.Date(2020, 6, 15, 0, 0, 0, 0, time.UTC), true},
+		{`{"release_date":"2020-06","release_date_precision":"month"}`, PrecisionMonth, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{`{"release_date":"2020","release_date_precision":"year"}`, PrecisionYear, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		// Without the precision, it is guessed from the date
+		{`{"release_date":"2020-06"}`, PrecisionMonth, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		// The precision is trusted over the date
+		{`{"release_date":"2020-06-15","release_date_precision":"year"}`, "", time.Time{}, false},
+		{`{"release_date":"2020","release_date_precision":"century"}`, "", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		var a SimplifiedAlbumObject
+		if err := json.Unmarshal([]byte(tt.json), &a); err != nil {
+			t.Errorf("%s: %v", tt.json, err)
+			continue
+		}
+		d := a.ReleaseDate
+		if d.Valid() != tt.wantValid || d.Precision != tt.wantPrecision || !d.Time.Equal(tt.wantTime) {
+			t.Errorf("%s: got %+v, want precision %q at %v", tt.json, d, tt.wantPrecision, tt.wantTime)
+		}
+	}
+}
+
+func TestReleaseDateLastDay(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want time.Time
+	}{
+		{"2020-06-15", time.Date(2020, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{"2020-02", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"2020", time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		d, err := ParseReleaseDate(tt.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.LastDay(); !got.Equal(tt.want) {
+			t.Errorf("LastDay of %q = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
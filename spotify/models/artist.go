@@ -1,7 +1,9 @@
 package models
 
 import (
+	"context"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -48,6 +50,37 @@ type CursorBasedObj struct {
 	Total int `json:"total"`
 }
 
+// Paging : aka offset-based paging object is a container for a set of objects
+// whose pages are requested with an offset and a limit
+type Paging struct {
+	// A link to the Web API endpoint returning the full result of the request
+	Link string `json:"href"`
+
+	// The maximum number of items in the response
+	Limit int `json:"limit"`
+
+	// URL to the next page of items, empty if none
+	Next string `json:"next"`
+
+	// The offset of the items returned
+	Offset int `json:"offset"`
+
+	// URL to the previous page of items, empty if none
+	Previous string `json:"previous"`
+
+	// The total number of items available to return
+	Total int `json:"total"`
+}
+
+// pageLimit returns the number of items to request per page, at most maxPage
+// and no more than max when a maximum is set (max != -1)
+func pageLimit(maxPage, max int) int {
+	if max != -1 && max < maxPage {
+		return max
+	}
+	return maxPage
+}
+
 // FullArtistCursorPage : Is the full object returned by the API Endpoint '/v1/me/following?type=artist'
 type FullArtistCursorPage struct {
 	CursorBasedObj
@@ -58,25 +91,41 @@ type FullArtistCursorPage struct {
 // --------------------------------  FUNCTIONS  -------------------------------- //
 // //////////////////////////////////////////////////////////////////////////// //
 
-// GetArtistAlbums :
-func (c *Client) GetArtistAlbums(id string) ([]*SimplifiedAlbumObject, error) {
+// GetArtistAlbums : Returns the albums of the artist belonging to the album groups
+// ("album", "single", "appears_on", "compilation"), albums and singles when none is given
+// Arg :
+// (1) - The Spotify ID of the artist
+// (2) - The maximum number of albums to return | *Put -1 to get them all
+// (3) - The album groups
+func (c *Client) GetArtistAlbums(id string, max int, groups ...string) ([]*SimplifiedAlbumObject, error) {
+	return c.GetArtistAlbumsContext(context.Background(), id, max, groups...)
+}
+
+// GetArtistAlbumsContext : Same as GetArtistAlbums, with a context to cancel the requests
+func (c *Client) GetArtistAlbumsContext(ctx context.Context, id string, max int, groups ...string) ([]*SimplifiedAlbumObject, error) {
+	return c.iterArtistAlbums(ctx, id, max, groups).All()
+}
+
+// IterArtistAlbums : Returns an iterator over the albums of the artist belonging to the album groups,
+// albums and singles when none is given
+func (c *Client) IterArtistAlbums(ctx context.Context, id string, groups ...string) *Iterator[*SimplifiedAlbumObject] {
+	return c.iterArtistAlbums(ctx, id, -1, groups)
+}
+
+func (c *Client) iterArtistAlbums(ctx context.Context, id string, max int, groups []string) *Iterator[*SimplifiedAlbumObject] {
+	if len(groups) == 0 {
+		groups = []string{"album", "single"}
+	}
 	// Set query parameters
 	v := url.Values{}
-	v.Set("include_groups", "album,single")
+	v.Set("include_groups", strings.Join(groups, ","))
+	v.Set("limit", strconv.Itoa(pageLimit(50, max)))
 
-	funcURL := c.BaseURL + "artists/id/albums"
-	funcURL = strings.Replace(funcURL, "id", id, -1)
+	funcURL := c.BaseURL + "artists/{id}/albums"
+	funcURL = strings.Replace(funcURL, "{id}", id, -1)
 	if params := v.Encode(); params != "" {
 		funcURL += "?" + params
 	}
 
-	var a struct {
-		Albums []*SimplifiedAlbumObject `json:"items"`
-	}
-
-	err := c.get(funcURL, &a)
-	if err != nil {
-		return nil, err
-	}
-	return a.Albums, nil
+	return newIterator(ctx, funcURL, max, offsetPages[*SimplifiedAlbumObject](c))
 }
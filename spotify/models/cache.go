@@ -0,0 +1,288 @@
+package models
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse : The body of a GET response kept with what is needed to revalidate it
+type CachedResponse struct {
+	Body []byte `json:"body"`
+	// ETag is sent back in If-None-Match to revalidate the body
+	ETag string `json:"etag"`
+	// Expires is when the body must be revalidated, from the Cache-Control max-age.
+	// Before that the body is used without asking the server.
+	Expires time.Time `json:"expires"`
+}
+
+// Cache : Storage of the GET responses of a Client, keyed by URL
+type Cache interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, r *CachedResponse)
+	// Invalidate forgets the responses whose key starts with one of the prefixes
+	Invalidate(prefixes ...string)
+}
+
+// cachedGet sends the GET request through the client's cache.
+// A fresh cached body is used as is, a stale one is revalidated with If-None-Match
+// and a 304 Not Modified answer uses the cached body.
+func (c *Client) cachedGet(req *http.Request, result interface{}) error {
+	key := req.URL.String()
+	cached, ok := c.Cache.Get(key)
+	if ok && time.Now().Before(cached.Expires) {
+		return decodeBody(req, cached.Body, result)
+	}
+	if ok && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, attempts, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		cached.Expires = expires(resp.Header)
+		c.Cache.Set(key, cached)
+		return decodeBody(req, cached.Body, result)
+	case resp.StatusCode == http.StatusOK:
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return requestError(req, attempts, err)
+		}
+		if cacheable(resp.Header) {
+			c.Cache.Set(key, &CachedResponse{Body: body, ETag: resp.Header.Get("ETag"), Expires: expires(resp.Header)})
+		}
+		return decodeBody(req, body, result)
+	default:
+		return c.handleResponse(resp, result, attempts, nil)
+	}
+}
+
+// invalidate forgets the cached responses the modification req may have made stale, whether it succeeded
+// or not: those of the playlist it changed and the playlists of the user, which hold their snapshot IDs.
+// Otherwise a fresh response would be used as is until its max-age is over.
+func (c *Client) invalidate(req *http.Request) {
+	if c.Cache == nil || req.Method == "GET" {
+		return
+	}
+	origin := req.URL.Scheme + "://" + req.URL.Host
+	segments := strings.Split(req.URL.Path, "/")
+	for i := 1; i < len(segments); i++ {
+		// The API paths are /v1/playlists/{id}/... and /v1/users/{id}/playlists
+		isPlaylist := segments[i-1] == "playlists" && segments[i] != ""
+		isUserPlaylists := segments[i-1] == "users" && i+1 < len(segments) && segments[i+1] == "playlists"
+		if !isPlaylist && !isUserPlaylists {
+			continue
+		}
+		playlists := origin + strings.Join(segments[:i-1], "/") + "/me/playlists"
+		prefixes := []string{playlists + "?", playlists + "/"}
+		if isPlaylist {
+			playlist := origin + strings.Join(segments[:i+1], "/")
+			prefixes = append(prefixes, playlist+"?", playlist+"/")
+		}
+		c.Cache.Invalidate(prefixes...)
+		return
+	}
+}
+
+func decodeBody(req *http.Request, body []byte, result interface{}) error {
+	if err := json.Unmarshal(body, result); err != nil {
+		return &RequestError{Method: req.Method, URL: req.URL.String(), Err: err}
+	}
+	return nil
+}
+
+// cacheable reports whether the response can be stored: it must be allowed to
+// and either be revalidated with an ETag or stay fresh for some time
+func cacheable(h http.Header) bool {
+	if strings.Contains(h.Get("Cache-Control"), "no-store") {
+		return false
+	}
+	return h.Get("ETag") != "" || expires(h).After(time.Now())
+}
+
+// expires returns until when the response is fresh according to its Cache-Control max-age,
+// now when it must always be revalidated
+func expires(h http.Header) time.Time {
+	now := time.Now()
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "no-cache" {
+			return now
+		}
+		if strings.HasPrefix(directive, "max-age=") {
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				return now.Add(time.Duration(seconds) * time.Second)
+			}
+		}
+	}
+	return now
+}
+
+// MemoryCache : Cache keeping the most recently used responses in memory
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	// Most recently used first, the values are *memoryEntry
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key      string
+	response *CachedResponse
+}
+
+// NewMemoryCache : Creates a cache of up to capacity responses, evicting the least recently used ones
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (m *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	m.order.MoveToFront(e)
+	// A copy, so the caller can update it without racing with other readers
+	r := *e.Value.(*memoryEntry).response
+	return &r, true
+}
+
+func (m *MemoryCache) Set(key string, r *CachedResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[key]; ok {
+		e.Value.(*memoryEntry).response = r
+		m.order.MoveToFront(e)
+		return
+	}
+	m.entries[key] = m.order.PushFront(&memoryEntry{key: key, response: r})
+	for m.capacity > 0 && m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// Invalidate : Forgets the responses whose key starts with one of the prefixes
+func (m *MemoryCache) Invalidate(prefixes ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, e := range m.entries {
+		if hasPrefix(key, prefixes) {
+			m.order.Remove(e)
+			delete(m.entries, key)
+		}
+	}
+}
+
+// DiskCache : Cache keeping the responses in a directory, so they are kept between runs.
+// Each response is a file named after the hash of its URL.
+type DiskCache struct {
+	dir string
+}
+
+// diskEntry is the content of a DiskCache file, the key is kept to find the responses to invalidate
+type diskEntry struct {
+	Key string `json:"key"`
+	CachedResponse
+}
+
+// NewDiskCache : Creates a cache in dir, creating the directory if needed
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (d *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get : Reads the response of the key, a missing or unreadable file is a cache miss
+func (d *DiskCache) Get(key string) (*CachedResponse, bool) {
+	data, err := ioutil.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var e diskEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, false
+	}
+	return &e.CachedResponse, true
+}
+
+// Set : Writes the response of the key, failures are ignored as the response can be fetched again
+func (d *DiskCache) Set(key string, r *CachedResponse) {
+	data, err := json.Marshal(diskEntry{Key: key, CachedResponse: *r})
+	if err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(d.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), d.path(key)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// Invalidate : Removes the files of the responses whose key starts with one of the prefixes,
+// and the ones whose key is unknown
+func (d *DiskCache) Invalidate(prefixes ...string) {
+	files, err := filepath.Glob(filepath.Join(d.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var e diskEntry
+		if json.Unmarshal(data, &e) != nil || e.Key == "" || hasPrefix(e.Key, prefixes) {
+			os.Remove(f)
+		}
+	}
+}
+
+func hasPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
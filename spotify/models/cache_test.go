@@ -0,0 +1,161 @@
+package models_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+func TestCachedGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		cache  func(t *testing.T) models.Cache
+		maxAge time.Duration
+		// wantRequests is the number of requests sent for two reads of the user
+		wantRequests int
+	}{
+		{"no cache", nil, time.Hour, 2},
+		{"memory fresh", memoryCache, time.Hour, 1},
+		{"memory revalidated", memoryCache, 0, 2},
+		{"disk fresh", diskCache, time.Hour, 1},
+		{"disk revalidated", diskCache, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := spotifytest.NewServer()
+			defer s.Close()
+			s.CacheMaxAge(tt.maxAge)
+			c := s.Client()
+			if tt.cache != nil {
+				c.Cache = tt.cache(t)
+			}
+
+			for i := 0; i < 2; i++ {
+				u, err := c.CurrentUser()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if u.ID != "spotifytest" {
+					t.Errorf("read %d: user %q, want spotifytest", i, u.ID)
+				}
+			}
+			if got := s.Requests(); got != tt.wantRequests {
+				t.Errorf("sent %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+// TestCacheInvalidatedByWrite reads a playlist cached for an hour again after modifying it
+func TestCacheInvalidatedByWrite(t *testing.T) {
+	for name, cache := range map[string]func(t *testing.T) models.Cache{"memory": memoryCache, "disk": diskCache} {
+		t.Run(name, func(t *testing.T) {
+			s := spotifytest.NewServer()
+			defer s.Close()
+			s.CacheMaxAge(time.Hour)
+			c := s.Client()
+			c.Cache = cache(t)
+			id := s.AddPlaylist("Releases", "spotify:track:a")
+			// Read the user to check that only the playlist is forgotten
+			if _, err := c.CurrentUser(); err != nil {
+				t.Fatal(err)
+			}
+			before, err := c.GetPlaylist(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.GetPlaylistTracks(id); err != nil {
+				t.Fatal(err)
+			}
+
+			snapshotID, err := c.AddTracksToPlaylist(id, []string{"spotify:track:b"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := c.GetPlaylist(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			items, err := c.GetPlaylistTracks(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.SnapshotID != snapshotID || after.SnapshotID == before.SnapshotID || len(items) != 2 {
+				t.Errorf("read snapshot %q with %d tracks after the addition, want %q with 2", after.SnapshotID, len(items), snapshotID)
+			}
+
+			requests := s.Requests()
+			if _, err := c.CurrentUser(); err != nil {
+				t.Fatal(err)
+			}
+			if s.Requests() != requests {
+				t.Error("the user was requested again")
+			}
+		})
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	c := models.NewMemoryCache(2)
+	c.Set("a", &models.CachedResponse{ETag: "a"})
+	c.Set("b", &models.CachedResponse{ETag: "b"})
+	c.Get("a")
+	// b is the least recently used
+	c.Set("c", &models.CachedResponse{ETag: "c"})
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("Get(%q) found %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	keys := []string{"https://api/v1/playlists/1?limit=100", "https://api/v1/playlists/1/tracks", "https://api/v1/playlists/12", "https://api/v1/me"}
+	want := map[string]bool{keys[0]: false, keys[1]: false, keys[2]: true, keys[3]: true}
+	for name, cache := range map[string]func(t *testing.T) models.Cache{"memory": memoryCache, "disk": diskCache} {
+		t.Run(name, func(t *testing.T) {
+			c := cache(t)
+			for _, k := range keys {
+				c.Set(k, &models.CachedResponse{Body: []byte(`{}`)})
+			}
+			c.Invalidate("https://api/v1/playlists/1?", "https://api/v1/playlists/1/")
+			for _, k := range keys {
+				if _, ok := c.Get(k); ok != want[k] {
+					t.Errorf("Get(%q) found %v, want %v", k, ok, want[k])
+				}
+			}
+		})
+	}
+}
+
+func TestDiskCacheInvalidateUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	c, err := models.NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A file written without its key can't tell what it holds
+	if err := os.WriteFile(filepath.Join(dir, "old.json"), []byte(`{"body":"e30=","etag":"x"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c.Invalidate("https://api/v1/playlists/1/")
+	if _, err := os.Stat(filepath.Join(dir, "old.json")); !os.IsNotExist(err) {
+		t.Errorf("the file without a key was kept: %v", err)
+	}
+}
+
+func memoryCache(t *testing.T) models.Cache {
+	return models.NewMemoryCache(100)
+}
+
+func diskCache(t *testing.T) models.Cache {
+	c, err := models.NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
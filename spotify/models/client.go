@@ -2,11 +2,15 @@ package models
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -46,15 +50,44 @@ type Client struct {
 	Http    *http.Client
 	BaseURL string
 
+	// AutoRetry retries the rate limited and failed requests with the DefaultRetryPolicy
 	AutoRetry bool
+	// Retry is the policy used to retry failed requests, it takes precedence over AutoRetry
+	Retry *RetryPolicy
+	// Limiter spaces out the requests, nil sends them as they come.
+	// The same limiter can be shared by several clients.
+	Limiter *RateLimiter
+	// Cache keeps the GET responses to revalidate them with their ETag, nil disables caching.
+	// Responses are cached by URL, a cache must not be shared by clients of different users.
+	// The responses about a playlist are forgotten when the client modifies it.
+	Cache Cache
+	// Metrics counts the requests and their latency per endpoint, nil disables them
+	Metrics *Metrics
+	// AppOnly is set for the clients without a user, made by Authenticator.NewAppClient.
+	// Their requests to the user endpoints fail with ErrUserRequired without being sent.
+	AppOnly bool
 }
 
 // Error : Represents an error returned by the Spotify Web API.
+// It can be compared to the ErrUnauthorized, ErrForbidden, ErrNotFound, ErrRateLimited
+// and ErrServerError sentinels with errors.Is.
 type Error struct {
 	// A short description of the error.
 	Message string `json:"message"`
 	// The HTTP status code.
 	Status int `json:"status"`
+	// The Spotify reason code, like "PREMIUM_REQUIRED", or the OAuth error code
+	// like "invalid_grant" for the Accounts service. Often empty.
+	Reason string `json:"reason"`
+	// The method and URL of the request that failed
+	Method string `json:"-"`
+	URL    string `json:"-"`
+	// How long the server asked to wait before retrying, 0 if it did not tell
+	RetryAfter time.Duration `json:"-"`
+	// The raw body of the response
+	Body []byte `json:"-"`
+	// The number of times the request was sent
+	Attempts int `json:"-"`
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////
@@ -62,110 +95,195 @@ type Error struct {
 /////////////////////////// ********* FUNCTIONS ********* ///////////////////////////////
 
 // Return the response
-func (c *Client) get(url string, result interface{}) error {
-	for {
-		resp, err := c.Http.Get(url)
-		if err != nil {
-			return err
-		}
+func (c *Client) get(ctx context.Context, url string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if c.Cache != nil {
+		return c.cachedGet(req, result)
+	}
+	return c.execute(req, result)
+}
 
-		defer resp.Body.Close()
+// `execute` executes a request. `needsStatus` describes other HTTP
+// status codes that will be treated as success. Note that we allow all 200s
+// even if there are additional success codes that represent success.
+func (c *Client) execute(req *http.Request, result interface{}, needsStatus ...int) error {
+	resp, attempts, err := c.send(req)
+	if attempts > 0 {
+		c.invalidate(req)
+	}
+	if err != nil {
+		return err
+	}
+	return c.handleResponse(resp, result, attempts, needsStatus)
+}
 
-		if resp.StatusCode == rateLimitExceededStatusCode && c.AutoRetry {
-			time.Sleep(retryDuration(resp))
-			continue
+// send sends the request and returns the response of the last attempt with the number of attempts.
+// Failed requests are sent again according to the client's retry policy,
+// the body of the request is rewound before each new attempt.
+func (c *Client) send(req *http.Request) (resp *http.Response, attempt int, err error) {
+	if c.AppOnly && c.needsUser(req) {
+		return nil, 0, fmt.Errorf("%w: %s %s", ErrUserRequired, req.Method, req.URL)
+	}
+	defer func() { count(req.Context(), attempt) }()
+	policy := c.retryPolicy()
+	start := time.Now()
+	for attempt = 1; ; attempt++ {
+		if attempt > 1 && req.Body != nil && req.Body != http.NoBody {
+			// The previous attempt consumed the body
+			if req.GetBody == nil {
+				return nil, attempt - 1, requestError(req, attempt-1, errors.New("can't rewind the request body to retry it"))
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, attempt - 1, requestError(req, attempt-1, err)
+			}
+			req.Body = body
 		}
-		if resp.StatusCode == http.StatusNoContent {
-			return nil
+
+		if c.Limiter != nil {
+			if err := c.Limiter.Wait(req.Context()); err != nil {
+				return nil, attempt - 1, requestError(req, attempt-1, err)
+			}
 		}
-		if resp.StatusCode != http.StatusOK {
-			return c.decodeError(resp)
+		sent := time.Now()
+		resp, err := c.Http.Do(req)
+		if c.Metrics != nil {
+			c.Metrics.Observe(req, resp, time.Since(sent))
 		}
-
-		err = json.NewDecoder(resp.Body).Decode(result)
 		if err != nil {
-			return err
+			if policy != nil && policy.retryableError(req) {
+				if wait, ok := policy.next(attempt, time.Since(start), nil); ok {
+					if err := sleep(req.Context(), wait); err != nil {
+						return nil, attempt, requestError(req, attempt, err)
+					}
+					continue
+				}
+			}
+			return nil, attempt, requestError(req, attempt, err)
+		}
+		if resp.StatusCode == rateLimitExceededStatusCode && c.Limiter != nil {
+			c.Limiter.SlowDown()
 		}
 
-		break
+		if policy != nil && policy.retryable(req, resp.StatusCode) {
+			if wait, ok := policy.next(attempt, time.Since(start), resp); ok {
+				// Drain the body so the connection can be reused
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				if err := sleep(req.Context(), wait); err != nil {
+					return nil, attempt, requestError(req, attempt, err)
+				}
+				continue
+			}
+		}
+		return resp, attempt, nil
 	}
+}
 
-	return nil
+// requestError returns the RequestError of the request that failed after the attempts
+func requestError(req *http.Request, attempts int, err error) *RequestError {
+	return &RequestError{Method: req.Method, URL: req.URL.String(), Err: err, Attempts: attempts}
 }
 
-// `execute` executes a non-GET request. `needsStatus` describes other HTTP
-// status codes that will be treated as success. Note that we allow all 200s
-// even if there are additional success codes that represent success.
-func (c *Client) execute(req *http.Request, result interface{}, needsStatus ...int) error {
-	for {
-		resp, err := c.Http.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
+// needsUser reports whether the request acts on behalf of a user: the /me endpoints,
+// and every modification as they all change a user's library or playlists
+func (c *Client) needsUser(req *http.Request) bool {
+	if req.Method != "GET" {
+		return true
+	}
+	path := strings.TrimPrefix(req.URL.String(), c.BaseURL)
+	return path == "me" || strings.HasPrefix(path, "me/") || strings.HasPrefix(path, "me?")
+}
 
-		if c.AutoRetry && shouldRetry(resp.StatusCode) {
-			time.Sleep(retryDuration(resp))
-			continue
-		}
-		if resp.StatusCode == http.StatusNoContent {
-			return nil
-		}
-		if (resp.StatusCode >= 300 ||
-			resp.StatusCode < 200) &&
-			isFailure(resp.StatusCode, needsStatus) {
-			return c.decodeError(resp)
+// handleResponse decodes the response of the last attempt into result, or the error it holds
+func (c *Client) handleResponse(resp *http.Response, result interface{}, attempts int, needsStatus []int) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if (resp.StatusCode >= 300 ||
+		resp.StatusCode < 200) &&
+		isFailure(resp.StatusCode, needsStatus) {
+		switch err := c.decodeError(resp).(type) {
+		case Error:
+			err.Attempts = attempts
+			return err
+		case *RequestError:
+			err.Attempts = attempts
+			return err
+		default:
+			return err
 		}
+	}
 
-		if result != nil {
-			if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
-				return err
-			}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return requestError(resp.Request, attempts, err)
 		}
-		break
 	}
 	return nil
 }
 
-// decodeError decodes an Error from an io.Reader.
+// decodeError decodes an Error from the response of a failed request.
 func (c *Client) decodeError(resp *http.Response) error {
+	e := Error{
+		Status:     resp.StatusCode,
+		RetryAfter: retryAfter(resp),
+	}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		e.URL = resp.Request.URL.String()
+	}
+
 	responseBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return &RequestError{Method: e.Method, URL: e.URL, Err: err}
 	}
+	e.Body = responseBody
 
 	if len(responseBody) == 0 {
-		return fmt.Errorf("spotify: HTTP %d: %s (body empty)", resp.StatusCode, http.StatusText(resp.StatusCode))
+		e.Message = fmt.Sprintf("HTTP %d: %s (body empty)", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return e
 	}
 
-	buf := bytes.NewBuffer(responseBody)
-
-	var e struct {
-		E Error `json:"error"`
+	// The Web API sends {"error": {"status": 404, "message": "..."}}, while the Accounts
+	// service sends {"error": "invalid_grant", "error_description": "..."}
+	var body struct {
+		E           json.RawMessage `json:"error"`
+		Description string          `json:"error_description"`
 	}
-	err = json.NewDecoder(buf).Decode(&e)
-	if err != nil {
-		return fmt.Errorf("spotify: couldn't decode error: (%d) [%s]", len(responseBody), responseBody)
+	var apiError struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+	}
+	err = json.NewDecoder(bytes.NewBuffer(responseBody)).Decode(&body)
+	switch {
+	case err != nil || len(body.E) == 0:
+		e.Message = fmt.Sprintf("couldn't decode error: (%d) [%s]", len(responseBody), responseBody)
+	case json.Unmarshal(body.E, &e.Reason) == nil:
+		e.Message = body.Description
+	case json.Unmarshal(body.E, &apiError) == nil:
+		e.Message, e.Reason = apiError.Message, apiError.Reason
+	default:
+		e.Message = fmt.Sprintf("couldn't decode error: (%d) [%s]", len(responseBody), responseBody)
 	}
 
-	if e.E.Message == "" {
+	if e.Message == "" {
 		// Some errors will result in there being a useful status-code but an
 		// empty message, which will confuse the user (who only has access to
 		// the message and not the code). An example of this is when we send
 		// some of the arguments directly in the HTTP query and the URL ends-up
 		// being too long.
 
-		e.E.Message = fmt.Sprintf("spotify: unexpected HTTP %d: %s (empty error)",
+		e.Message = fmt.Sprintf("unexpected HTTP %d: %s (empty error)",
 			resp.StatusCode, http.StatusText(resp.StatusCode))
 	}
 
-	return e.E
-}
-
-// shouldRetry determines whether the status code indicates that the
-// previous operation should be retried at a later time
-func shouldRetry(status int) bool {
-	return status == http.StatusAccepted || status == http.StatusTooManyRequests
+	return e
 }
 
 // isFailure determines whether the code indicates failure
@@ -178,18 +296,34 @@ func isFailure(code int, validCodes []int) bool {
 	return true
 }
 
-func retryDuration(resp *http.Response) time.Duration {
-	raw := resp.Header.Get("Retry-After")
-	if raw == "" {
-		return defaultRetryDuration
-	}
-	seconds, err := strconv.ParseInt(raw, 10, 32)
-	if err != nil {
-		return defaultRetryDuration
+// retryAfter returns the wait asked by the Retry-After header, 0 if it is missing or invalid
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.ParseInt(resp.Header.Get("Retry-After"), 10, 32)
+	if err != nil || seconds < 0 {
+		return 0
 	}
 	return time.Duration(seconds) * time.Second
 }
 
+// sleep waits for d, or returns the context error if ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 func (e Error) Error() string {
-	return e.Message
+	msg := e.Message
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(" (after %d attempts)", e.Attempts)
+	}
+	if e.URL == "" {
+		return "spotify: " + msg
+	}
+	return fmt.Sprintf("spotify: %s %s: %s", e.Method, e.URL, msg)
 }
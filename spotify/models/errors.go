@@ -0,0 +1,61 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sentinels matching the Error returned for each kind of failure, to use with errors.Is
+var (
+	// ErrUnauthorized : The access token is missing, expired or revoked (HTTP 401)
+	ErrUnauthorized = errors.New("spotify: unauthorized")
+	// ErrForbidden : The request is refused, usually because the token lacks a scope (HTTP 403)
+	ErrForbidden = errors.New("spotify: forbidden or insufficient scope")
+	// ErrNotFound : The requested resource does not exist (HTTP 404)
+	ErrNotFound = errors.New("spotify: not found")
+	// ErrRateLimited : Too many requests were sent, see Error.RetryAfter (HTTP 429)
+	ErrRateLimited = errors.New("spotify: rate limited")
+	// ErrServerError : Spotify failed to process the request (HTTP 5xx)
+	ErrServerError = errors.New("spotify: server error")
+	// ErrUserRequired : The endpoint acts on behalf of a user, it can't be called by a client with an app-only token
+	ErrUserRequired = errors.New("spotify: endpoint needs a user login, the client only has an app token")
+)
+
+// Is : Matches the error against the sentinel of its status code
+func (e Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Status == http.StatusForbidden
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrRateLimited:
+		return e.Status == http.StatusTooManyRequests
+	case ErrServerError:
+		return e.Status >= 500 && e.Status < 600
+	}
+	return false
+}
+
+// RequestError : A request that failed without an answer from the API,
+// because of the network, a cancelled context or a response that could not be decoded
+type RequestError struct {
+	Method string
+	URL    string
+	Err    error
+	// The number of times the request was sent, 0 when it failed before being sent
+	Attempts int
+}
+
+func (e *RequestError) Error() string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("spotify: %s %s: %v (after %d attempts)", e.Method, e.URL, e.Err, e.Attempts)
+	}
+	return fmt.Sprintf("spotify: %s %s: %v", e.Method, e.URL, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
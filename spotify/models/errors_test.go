@@ -0,0 +1,137 @@
+package models_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+// answer returns a client whose requests are all answered with the status, header and body
+func answer(status int, header http.Header, body string) *models.Client {
+	return &models.Client{
+		Http: &http.Client{Transport: models.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				Status:     http.StatusText(status),
+				StatusCode: status,
+				Header:     header,
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		})},
+		BaseURL: "https://api.spotify.test/v1/",
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	sentinels := []error{
+		models.ErrUnauthorized, models.ErrForbidden, models.ErrNotFound,
+		models.ErrRateLimited, models.ErrServerError, models.ErrUserRequired,
+	}
+	tests := []struct {
+		name        string
+		status      int
+		header      http.Header
+		body        string
+		wantMessage string
+		wantReason  string
+		wantRetry   time.Duration
+		// wantIs is the only sentinel matching the error, nil for none
+		wantIs error
+	}{
+		{
+			name:        "Web API object",
+			status:      404,
+			body:        `{"error":{"status":404,"message":"Non existing id"}}`,
+			wantMessage: "Non existing id",
+			wantIs:      models.ErrNotFound,
+		},
+		{
+			name:        "Web API object with a reason",
+			status:      403,
+			body:        `{"error":{"status":403,"message":"Player command failed: Premium required","reason":"PREMIUM_REQUIRED"}}`,
+			wantMessage: "Player command failed: Premium required",
+			wantReason:  "PREMIUM_REQUIRED",
+			wantIs:      models.ErrForbidden,
+		},
+		{
+			name:        "Accounts string",
+			status:      400,
+			body:        `{"error":"invalid_grant","error_description":"Invalid refresh token"}`,
+			wantMessage: "Invalid refresh token",
+			wantReason:  "invalid_grant",
+		},
+		{
+			name:        "Accounts string without description",
+			status:      401,
+			body:        `{"error":"invalid_client"}`,
+			wantMessage: "unexpected HTTP 401: Unauthorized (empty error)",
+			wantReason:  "invalid_client",
+			wantIs:      models.ErrUnauthorized,
+		},
+		{
+			name:        "rate limited",
+			status:      429,
+			header:      http.Header{"Retry-After": {"3"}},
+			body:        `{"error":{"status":429,"message":"API rate limit exceeded"}}`,
+			wantMessage: "API rate limit exceeded",
+			wantRetry:   3 * time.Second,
+			wantIs:      models.ErrRateLimited,
+		},
+		{
+			name:        "empty body",
+			status:      500,
+			wantMessage: "HTTP 500: Internal Server Error (body empty)",
+			wantIs:      models.ErrServerError,
+		},
+		{
+			name:        "not JSON",
+			status:      502,
+			body:        `<html>Bad Gateway</html>`,
+			wantMessage: "couldn't decode error: (24) [<html>Bad Gateway</html>]",
+			wantIs:      models.ErrServerError,
+		},
+		{
+			name:        "no error field",
+			status:      503,
+			body:        `{"message":"down"}`,
+			wantMessage: `couldn't decode error: (18) [{"message":"down"}]`,
+			wantIs:      models.ErrServerError,
+		},
+		{
+			name:        "Web API object without message",
+			status:      400,
+			body:        `{"error":{"status":400}}`,
+			wantMessage: "unexpected HTTP 400: Bad Request (empty error)",
+		},
+	}
+	for _, tt := range tests {
+		header := http.Header{"Content-Type": {"application/json"}}
+		for k, v := range tt.header {
+			header[k] = v
+		}
+		_, err := answer(tt.status, header, tt.body).CurrentUser()
+
+		var e models.Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: error = %#v, want an Error", tt.name, err)
+			continue
+		}
+		if e.Status != tt.status || e.Message != tt.wantMessage || e.Reason != tt.wantReason || e.RetryAfter != tt.wantRetry {
+			t.Errorf("%s: Error = status %d, message %q, reason %q, retry after %s, want %d, %q, %q, %s",
+				tt.name, e.Status, e.Message, e.Reason, e.RetryAfter, tt.status, tt.wantMessage, tt.wantReason, tt.wantRetry)
+		}
+		if e.Method != "GET" || e.URL != "https://api.spotify.test/v1/me" || string(e.Body) != tt.body {
+			t.Errorf("%s: Error = %s %s with body %q, want the request and the raw body", tt.name, e.Method, e.URL, e.Body)
+		}
+		for _, sentinel := range sentinels {
+			if got, want := errors.Is(err, sentinel), sentinel == tt.wantIs; got != want {
+				t.Errorf("%s: errors.Is(err, %v) = %v, want %v", tt.name, sentinel, got, want)
+			}
+		}
+	}
+}
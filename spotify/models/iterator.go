@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Iterator : Walks the items of a paged endpoint lazily, requesting the next page
+// only once the items of the current one were all read.
+//
+//	it := client.IterArtistAlbums(ctx, id)
+//	for it.Next() {
+//		album := it.Item()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type Iterator[T any] struct {
+	ctx   context.Context
+	fetch pageFetcher[T]
+	// URL of the next page, empty after the last one
+	url string
+	// requested are the URLs of the pages already requested, a page pointing back to one fails the iteration
+	requested map[string]bool
+	// Maximum number of items to return, -1 for all of them
+	max   int
+	count int
+
+	page    []T
+	i       int
+	item    T
+	err     error
+	stopped bool
+}
+
+// pageFetcher requests the page at url, returning its items and the URL of the next page
+type pageFetcher[T any] func(ctx context.Context, url string) ([]T, string, error)
+
+func newIterator[T any](ctx context.Context, url string, max int, fetch pageFetcher[T]) *Iterator[T] {
+	return &Iterator[T]{ctx: ctx, url: url, max: max, fetch: fetch, requested: map[string]bool{}}
+}
+
+// Next : Moves to the next item, requesting the next page if needed.
+// Returns false when there are no more items, the iterator was stopped or a request failed.
+// An empty page ends the iteration even if it has a next page, and a next page already requested
+// fails it, so a misbehaving endpoint can't keep it going forever.
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil || it.stopped || (it.max != -1 && it.count >= it.max) {
+		return false
+	}
+	for it.i >= len(it.page) {
+		if it.url == "" {
+			return false
+		}
+		if it.requested[it.url] {
+			it.err = &RequestError{Method: "GET", URL: it.url, Err: errors.New("the next page is one already read")}
+			return false
+		}
+		it.requested[it.url] = true
+		items, next, err := it.fetch(it.ctx, it.url)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if len(items) == 0 {
+			it.url = ""
+			return false
+		}
+		it.page, it.i, it.url = items, 0, next
+	}
+	it.item = it.page[it.i]
+	it.i++
+	it.count++
+	return true
+}
+
+// Item : Returns the current item, valid after Next returned true
+func (it *Iterator[T]) Item() T {
+	return it.item
+}
+
+// Err : Returns the error that stopped the iteration, nil if it ended normally
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Stop : Ends the iteration early, no other page is requested
+func (it *Iterator[T]) Stop() {
+	it.stopped = true
+}
+
+// ForEach : Calls fn for every remaining item until it returns false
+func (it *Iterator[T]) ForEach(fn func(T) bool) error {
+	for it.Next() {
+		if !fn(it.Item()) {
+			it.Stop()
+			break
+		}
+	}
+	return it.Err()
+}
+
+// All : Collects every remaining item
+func (it *Iterator[T]) All() ([]T, error) {
+	var items []T
+	for it.Next() {
+		items = append(items, it.Item())
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	return items, nil
+}
+
+// offsetPages reads the pages of an endpoint returning a Paging object
+func offsetPages[T any](c *Client) pageFetcher[T] {
+	return func(ctx context.Context, url string) ([]T, string, error) {
+		var page struct {
+			Paging
+			Items []T `json:"items"`
+		}
+		if err := c.get(ctx, url, &page); err != nil {
+			return nil, "", err
+		}
+		return page.Items, page.Next, nil
+	}
+}
+
+// cursorPages reads the pages of an endpoint returning a CursorBasedObj.
+// Some endpoints wrap it in an object, key is then the name of its field.
+func cursorPages[T any](c *Client, key string) pageFetcher[T] {
+	return func(ctx context.Context, url string) ([]T, string, error) {
+		var page struct {
+			CursorBasedObj
+			Items []T `json:"items"`
+		}
+		if key == "" {
+			if err := c.get(ctx, url, &page); err != nil {
+				return nil, "", err
+			}
+			return page.Items, page.Next, nil
+		}
+
+		var wrapper map[string]json.RawMessage
+		if err := c.get(ctx, url, &wrapper); err != nil {
+			return nil, "", err
+		}
+		raw, ok := wrapper[key]
+		if !ok {
+			return nil, "", &RequestError{Method: "GET", URL: url, Err: fmt.Errorf("no %q in the response", key)}
+		}
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return nil, "", &RequestError{Method: "GET", URL: url, Err: err}
+		}
+		return page.Items, page.Next, nil
+	}
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakePage is a page served by the fetcher of the tests, with its items and the URL of the next one
+type fakePage struct {
+	items []int
+	next  string
+}
+
+func TestIterator(t *testing.T) {
+	failure := errors.New("failure")
+	tests := []struct {
+		name      string
+		pages     map[string]fakePage
+		max       int
+		want      []int
+		wantFetch int
+		wantErr   bool
+	}{
+		{
+			name:      "every page",
+			pages:     map[string]fakePage{"1": {[]int{1, 2}, "2"}, "2": {[]int{3}, "3"}, "3": {[]int{4, 5}, ""}},
+			max:       -1,
+			want:      []int{1, 2, 3, 4, 5},
+			wantFetch: 3,
+		},
+		{
+			name:      "max",
+			pages:     map[string]fakePage{"1": {[]int{1, 2}, "2"}, "2": {[]int{3, 4}, "3"}, "3": {[]int{5}, ""}},
+			max:       3,
+			want:      []int{1, 2, 3},
+			wantFetch: 2,
+		},
+		{
+			name:      "no items",
+			pages:     map[string]fakePage{"1": {nil, ""}},
+			max:       -1,
+			wantFetch: 1,
+		},
+		{
+			name:      "empty page with a next one",
+			pages:     map[string]fakePage{"1": {[]int{1}, "2"}, "2": {nil, "3"}, "3": {[]int{2}, ""}},
+			max:       -1,
+			want:      []int{1},
+			wantFetch: 2,
+		},
+		{
+			name:      "empty page pointing to itself",
+			pages:     map[string]fakePage{"1": {nil, "1"}},
+			max:       -1,
+			wantFetch: 1,
+		},
+		{
+			name:      "page pointing to itself",
+			pages:     map[string]fakePage{"1": {[]int{1}, "2"}, "2": {[]int{2}, "2"}},
+			max:       -1,
+			want:      []int{1, 2},
+			wantFetch: 2,
+			wantErr:   true,
+		},
+		{
+			name:      "pages in a loop",
+			pages:     map[string]fakePage{"1": {[]int{1}, "2"}, "2": {[]int{2}, "1"}},
+			max:       -1,
+			want:      []int{1, 2},
+			wantFetch: 2,
+			wantErr:   true,
+		},
+		{
+			name:      "failed page",
+			pages:     map[string]fakePage{"1": {[]int{1}, "2"}},
+			max:       -1,
+			want:      []int{1},
+			wantFetch: 2,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetched := 0
+			fetch := func(ctx context.Context, url string) ([]int, string, error) {
+				fetched++
+				if fetched > 10 {
+					t.Fatal("the iteration does not end")
+				}
+				page, ok := tt.pages[url]
+				if !ok {
+					return nil, "", failure
+				}
+				return page.items, page.next, nil
+			}
+
+			it := newIterator(context.Background(), "1", tt.max, fetch)
+			var got []int
+			for it.Next() {
+				got = append(got, it.Item())
+			}
+			if (it.Err() != nil) != tt.wantErr {
+				t.Errorf("Err() = %v, want error %v", it.Err(), tt.wantErr)
+			}
+			if !equalInts(got, tt.want) || fetched != tt.wantFetch {
+				t.Errorf("got %v in %d requests, want %v in %d", got, fetched, tt.want, tt.wantFetch)
+			}
+		})
+	}
+}
+
+func TestIteratorStop(t *testing.T) {
+	fetched := 0
+	fetch := func(ctx context.Context, url string) ([]int, string, error) {
+		fetched++
+		return []int{1, 2}, "next", nil
+	}
+	it := newIterator(context.Background(), "first", -1, fetch)
+	var got []int
+	err := it.ForEach(func(i int) bool {
+		got = append(got, i)
+		return len(got) < 3
+	})
+	if err != nil || len(got) != 3 || fetched != 2 {
+		t.Errorf("ForEach read %v in %d requests with %v, want 3 items in 2 requests", got, fetched, err)
+	}
+	if it.Next() {
+		t.Error("Next after Stop returned true")
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the request duration histogram buckets
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// idCollections are the path segments followed by an ID, replaced by {id} in the endpoint label
+// so all the artists or playlists share the same metrics
+var idCollections = map[string]bool{
+	"albums":    true,
+	"artists":   true,
+	"episodes":  true,
+	"playlists": true,
+	"shows":     true,
+	"tracks":    true,
+	"users":     true,
+}
+
+// Metrics : Counters and latency histograms of the requests sent by a Client, per endpoint.
+// It is an http.Handler serving them in the Prometheus text exposition format.
+// The same Metrics can be shared by several clients.
+type Metrics struct {
+	mu sync.Mutex
+	// Upper bounds of the latency buckets, in seconds
+	buckets []float64
+	// The number of requests per endpoint, method and status, "error" when no response came back
+	requests map[requestLabels]uint64
+	latency  map[endpointLabels]*histogram
+}
+
+type endpointLabels struct {
+	endpoint string
+	method   string
+}
+
+type requestLabels struct {
+	endpointLabels
+	status string
+}
+
+type histogram struct {
+	// counts[i] is the number of observations in bucket i only, they are summed up when written
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewMetrics : Creates metrics with the latency buckets, DefaultLatencyBuckets if none are given
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Metrics{
+		buckets:  b,
+		requests: map[requestLabels]uint64{},
+		latency:  map[endpointLabels]*histogram{},
+	}
+}
+
+// Observe : Records an attempt of a request, resp is nil when it failed without a response
+func (m *Metrics) Observe(req *http.Request, resp *http.Response, d time.Duration) {
+	labels := endpointLabels{endpoint: Endpoint(req.URL), method: req.Method}
+	status := "error"
+	if resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{labels, status}]++
+	h, ok := m.latency[labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.latency[labels] = h
+	}
+	seconds := d.Seconds()
+	for i, upper := range m.buckets {
+		if seconds <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Endpoint : Returns the path of the URL with its IDs replaced by {id}, like "/v1/artists/{id}/albums"
+func Endpoint(u *url.URL) string {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 1; i < len(segments); i++ {
+		if idCollections[segments[i-1]] && segments[i] != "" {
+			segments[i] = "{id}"
+			// The segment after an ID is never one
+			i++
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// snapshot returns a copy of the counters and histograms, so they can be written without holding m.mu
+func (m *Metrics) snapshot() (map[requestLabels]uint64, map[endpointLabels]histogram) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	requests := make(map[requestLabels]uint64, len(m.requests))
+	for l, n := range m.requests {
+		requests[l] = n
+	}
+	latency := make(map[endpointLabels]histogram, len(m.latency))
+	for l, h := range m.latency {
+		latency[l] = histogram{counts: append([]uint64(nil), h.counts...), count: h.count, sum: h.sum}
+	}
+	return requests, latency
+}
+
+// Write : Writes the metrics in the Prometheus text exposition format.
+// A slow writer does not hold up the requests observed meanwhile.
+func (m *Metrics) Write(w io.Writer) error {
+	counts, latency := m.snapshot()
+
+	cw := bufio.NewWriter(w)
+	fmt.Fprintln(cw, "# HELP spotify_api_requests_total Requests sent to the Spotify API, retries included.")
+	fmt.Fprintln(cw, "# TYPE spotify_api_requests_total counter")
+	requests := make([]requestLabels, 0, len(counts))
+	for l := range counts {
+		requests = append(requests, l)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.endpointLabels != b.endpointLabels {
+			return a.endpointLabels.less(b.endpointLabels)
+		}
+		return a.status < b.status
+	})
+	for _, l := range requests {
+		fmt.Fprintf(cw, "spotify_api_requests_total{%s,status=%s} %d\n", l.endpointLabels, quoteLabel(l.status), counts[l])
+	}
+
+	fmt.Fprintln(cw, "# HELP spotify_api_request_duration_seconds Duration of the requests sent to the Spotify API.")
+	fmt.Fprintln(cw, "# TYPE spotify_api_request_duration_seconds histogram")
+	endpoints := make([]endpointLabels, 0, len(latency))
+	for l := range latency {
+		endpoints = append(endpoints, l)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].less(endpoints[j]) })
+	for _, l := range endpoints {
+		h := latency[l]
+		var cumulative uint64
+		for i, upper := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(cw, "spotify_api_request_duration_seconds_bucket{%s,le=%s} %d\n", l, quoteLabel(strconv.FormatFloat(upper, 'g', -1, 64)), cumulative)
+		}
+		fmt.Fprintf(cw, "spotify_api_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+		fmt.Fprintf(cw, "spotify_api_request_duration_seconds_sum{%s} %s\n", l, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(cw, "spotify_api_request_duration_seconds_count{%s} %d\n", l, h.count)
+	}
+	return cw.Flush()
+}
+
+// ServeHTTP : Serves the metrics to Prometheus
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Write(w)
+}
+
+func (l endpointLabels) less(o endpointLabels) bool {
+	if l.endpoint != o.endpoint {
+		return l.endpoint < o.endpoint
+	}
+	return l.method < o.method
+}
+
+func (l endpointLabels) String() string {
+	return "endpoint=" + quoteLabel(l.endpoint) + ",method=" + quoteLabel(l.method)
+}
+
+// labelEscaper escapes the backslashes, double quotes and line feeds of label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel returns the label value escaped and quoted
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
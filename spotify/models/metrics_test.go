@@ -0,0 +1,119 @@
+package models_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://api.spotify.com/v1/me", "/v1/me"},
+		{"https://api.spotify.com/v1/me/playlists?limit=50", "/v1/me/playlists"},
+		{"https://api.spotify.com/v1/artists/0OdUWJ0sBjDrqHygGUXeCF/albums?offset=20", "/v1/artists/{id}/albums"},
+		{"https://api.spotify.com/v1/albums/4aawyAB9vmqN3uQ7FjRGTy/tracks", "/v1/albums/{id}/tracks"},
+		{"https://api.spotify.com/v1/users/spotifytest/playlists", "/v1/users/{id}/playlists"},
+		{"https://api.spotify.com/v1/playlists/37i9dQZF1DXcBWIGoYBM5M/tracks", "/v1/playlists/{id}/tracks"},
+		{"https://api.spotify.com/v1/playlists/37i9dQZF1DXcBWIGoYBM5M", "/v1/playlists/{id}"},
+		// Several IDs in the query are not part of the path
+		{"https://api.spotify.com/v1/tracks?ids=a,b", "/v1/tracks"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := models.Endpoint(u); got != tt.want {
+			t.Errorf("Endpoint(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+// observe records a request to the URL with the method, answered with the status or failed when it is 0
+func observe(m *models.Metrics, method, rawURL string, status int, d time.Duration) {
+	req, _ := http.NewRequest(method, rawURL, nil)
+	var resp *http.Response
+	if status != 0 {
+		resp = &http.Response{StatusCode: status}
+	}
+	m.Observe(req, resp, d)
+}
+
+func TestMetricsWrite(t *testing.T) {
+	m := models.NewMetrics(1, 0.1)
+	observe(m, "GET", "https://api.spotify.com/v1/artists/a/albums", 200, 50*time.Millisecond)
+	observe(m, "GET", "https://api.spotify.com/v1/artists/b/albums?offset=20", 200, 500*time.Millisecond)
+	observe(m, "GET", "https://api.spotify.com/v1/artists/b/albums?offset=20", 429, 2*time.Second)
+	observe(m, "POST", "https://api.spotify.com/v1/playlists/p/tracks", 0, 250*time.Millisecond)
+
+	var b strings.Builder
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP spotify_api_requests_total Requests sent to the Spotify API, retries included.
+# TYPE spotify_api_requests_total counter
+spotify_api_requests_total{endpoint="/v1/artists/{id}/albums",method="GET",status="200"} 2
+spotify_api_requests_total{endpoint="/v1/artists/{id}/albums",method="GET",status="429"} 1
+spotify_api_requests_total{endpoint="/v1/playlists/{id}/tracks",method="POST",status="error"} 1
+# HELP spotify_api_request_duration_seconds Duration of the requests sent to the Spotify API.
+# TYPE spotify_api_request_duration_seconds histogram
+spotify_api_request_duration_seconds_bucket{endpoint="/v1/artists/{id}/albums",method="GET",le="0.1"} 1
+spotify_api_request_duration_seconds_bucket{endpoint="/v1/artists/{id}/albums",method="GET",le="1"} 2
+spotify_api_request_duration_seconds_bucket{endpoint="/v1/artists/{id}/albums",method="GET",le="+Inf"} 3
+spotify_api_request_duration_seconds_sum{endpoint="/v1/artists/{id}/albums",method="GET"} 2.55
+spotify_api_request_duration_seconds_count{endpoint="/v1/artists/{id}/albums",method="GET"} 3
+spotify_api_request_duration_seconds_bucket{endpoint="/v1/playlists/{id}/tracks",method="POST",le="0.1"} 0
+spotify_api_request_duration_seconds_bucket{endpoint="/v1/playlists/{id}/tracks",method="POST",le="1"} 1
+spotify_api_request_duration_seconds_bucket{endpoint="/v1/playlists/{id}/tracks",method="POST",le="+Inf"} 1
+spotify_api_request_duration_seconds_sum{endpoint="/v1/playlists/{id}/tracks",method="POST"} 0.25
+spotify_api_request_duration_seconds_count{endpoint="/v1/playlists/{id}/tracks",method="POST"} 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("Write wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+// blockingWriter blocks the writes until release is closed, telling on writing the first time it is called
+type blockingWriter struct {
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.writing:
+	default:
+		close(w.writing)
+	}
+	<-w.release
+	return len(p), nil
+}
+
+func TestMetricsSlowWriter(t *testing.T) {
+	m := models.NewMetrics()
+	observe(m, "GET", "https://api.spotify.com/v1/me", 200, time.Millisecond)
+
+	w := &blockingWriter{writing: make(chan struct{}), release: make(chan struct{})}
+	defer close(w.release)
+	go m.Write(w)
+	<-w.writing
+
+	// The requests are still observed while the scraper reads slowly
+	observed := make(chan struct{})
+	go func() {
+		observe(m, "GET", "https://api.spotify.com/v1/me", 200, time.Millisecond)
+		close(observed)
+	}()
+	select {
+	case <-observed:
+	case <-time.After(time.Second):
+		t.Fatal("Observe is blocked by a slow Write")
+	}
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader is the header holding the ID set by WithRequestID
+const RequestIDHeader = "X-Request-ID"
+
+// Middleware : Wraps the transport sending the requests of a Client, to observe or modify them.
+// Every attempt of a retried request goes through the middlewares.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc : Adapts a function to an http.RoundTripper
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain : Wraps the transport with the middlewares, the first one sees the requests first
+// and the responses last. A nil transport is http.DefaultTransport.
+func Chain(transport http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		transport = middlewares[i](transport)
+	}
+	return transport
+}
+
+// Use : Adds the middlewares around the transport of the client,
+// the requests go through them before the ones already added
+func (c *Client) Use(middlewares ...Middleware) {
+	if c.Http == nil {
+		c.Http = &http.Client{}
+	}
+	c.Http.Transport = Chain(c.Http.Transport, middlewares...)
+}
+
+// WithHeader : Sets the header on the requests that don't have it already
+func WithHeader(key, value string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(key) != "" {
+				return next.RoundTrip(req)
+			}
+			// A RoundTripper must not modify the request it is given
+			req = req.Clone(req.Context())
+			req.Header.Set(key, value)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// WithUserAgent : Sends the User-Agent on every request
+func WithUserAgent(userAgent string) Middleware {
+	return WithHeader("User-Agent", userAgent)
+}
+
+// WithAcceptLanguage : Asks for the names and descriptions in the languages, like "fr-CA, fr;q=0.9"
+func WithAcceptLanguage(languages string) Middleware {
+	return WithHeader("Accept-Language", languages)
+}
+
+// WithRequestID : Gives each request a random ID in the X-Request-ID header, to follow it in the logs.
+// Every attempt of a retried request gets its own ID.
+func WithRequestID() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(RequestIDHeader) != "" {
+				return next.RoundTrip(req)
+			}
+			id := make([]byte, 8)
+			if _, err := rand.Read(id); err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Header.Set(RequestIDHeader, hex.EncodeToString(id))
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// WithLogging : Logs the method, URL, status and duration of every request,
+// and its request ID when WithRequestID comes before. A nil logger uses the standard logger.
+func WithLogging(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			prefix := ""
+			if id := req.Header.Get(RequestIDHeader); id != "" {
+				prefix = "[" + id + "] "
+			}
+			elapsed := time.Since(start).Round(time.Millisecond)
+			if err != nil {
+				logger.Printf("%s%s %s: %v (%s)\n", prefix, req.Method, req.URL, err, elapsed)
+			} else {
+				logger.Printf("%s%s %s: %s (%s)\n", prefix, req.Method, req.URL, resp.Status, elapsed)
+			}
+			return resp, err
+		})
+	}
+}
+
+// WithFaultInjection : Answers a fraction of the requests, between 0 and 1, with the status
+// without sending them, to see how the program copes with failures
+func WithFaultInjection(fraction float64, status int) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if mathrand.Float64() >= fraction {
+				return next.RoundTrip(req)
+			}
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			body := fmt.Sprintf(`{"error":{"status":%d,"message":"injected fault"}}`, status)
+			return &http.Response{
+				Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+				StatusCode:    status,
+				Proto:         "HTTP/1.1",
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        http.Header{"Content-Type": {"application/json"}},
+				Body:          ioutil.NopCloser(strings.NewReader(body)),
+				ContentLength: int64(len(body)),
+				Request:       req,
+			}, nil
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package models_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+// record is a middleware appending name> to calls when it sees a request and <name when it sees the response
+func record(name string, calls *[]string) models.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return models.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			*calls = append(*calls, name+">")
+			resp, err := next.RoundTrip(req)
+			*calls = append(*calls, "<"+name)
+			return resp, err
+		})
+	}
+}
+
+// ok is a transport answering every request with 200 OK, after passing it to seen
+func ok(seen func(req *http.Request)) http.RoundTripper {
+	next := answer(http.StatusOK, http.Header{"Content-Type": {"application/json"}}, `{}`).Http.Transport
+	return models.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		seen(req)
+		return next.RoundTrip(req)
+	})
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	transport := models.Chain(ok(func(*http.Request) { calls = append(calls, "send") }),
+		record("a", &calls), record("b", &calls), record("c", &calls))
+	req, _ := http.NewRequest("GET", "https://api.spotify.test/v1/me", nil)
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a>", "b>", "c>", "send", "<c", "<b", "<a"}; !equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+
+	// The middlewares given to Use go before the ones already added
+	calls = nil
+	c := &models.Client{
+		Http:    &http.Client{Transport: ok(func(*http.Request) { calls = append(calls, "send") })},
+		BaseURL: "https://api.spotify.test/v1/",
+	}
+	c.Use(record("inner", &calls))
+	c.Use(record("outer", &calls))
+	if _, err := c.CurrentUser(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outer>", "inner>", "send", "<inner", "<outer"}; !equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestWithHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		want     string
+	}{
+		{name: "missing header set", existing: "", want: "SpotifyFunc"},
+		{name: "existing header kept", existing: "curl/8.0", want: "curl/8.0"},
+	}
+	for _, tt := range tests {
+		var got string
+		transport := models.Chain(ok(func(req *http.Request) { got = req.Header.Get("User-Agent") }),
+			models.WithUserAgent("SpotifyFunc"))
+		req, _ := http.NewRequest("GET", "https://api.spotify.test/v1/me", nil)
+		if tt.existing != "" {
+			req.Header.Set("User-Agent", tt.existing)
+		}
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: User-Agent = %q, want %q", tt.name, got, tt.want)
+		}
+		// A RoundTripper must not modify the request it is given
+		if req.Header.Get("User-Agent") != tt.existing {
+			t.Errorf("%s: the request given was modified", tt.name)
+		}
+	}
+}
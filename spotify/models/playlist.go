@@ -2,45 +2,400 @@ package models
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
+	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 )
 
-const _playlistNewNewID = "7bs6NLtazYeLmf7uqYs9he"
+// playlistIDPattern matches a Spotify ID, a 22 characters base62 string
+var playlistIDPattern = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+// SimplePlaylist : Contains basic information about a playlist
+type SimplePlaylist struct {
+	// Whether other users can modify the playlist
+	Collaborative bool `json:"collaborative"`
+	// The playlist description, only returned for modified, verified playlists
+	Description string `json:"description"`
+	// Known external URLs for this playlist
+	ExternalURLs map[string]string `json:"external_urls"`
+	// A link to the Web API endpoint providing full details of the playlist
+	Endpoint string `json:"href"`
+	// The Spotify ID for the playlist
+	ID string `json:"id"`
+	// Images for the playlist
+	Images []Image `json:"images"`
+	// The name of the playlist
+	Name string `json:"name"`
+	// The user who owns the playlist
+	Owner User `json:"owner"`
+	// Whether the playlist is public or private
+	Public bool `json:"public"`
+	// The version identifier for the current playlist
+	SnapshotID string `json:"snapshot_id"`
+	// The Spotify URI for the playlist
+	URI string `json:"uri"`
+}
 
 // ////////////////////////////////////////////////////////////////////////////// //
 // --------------------------------  FUNCTIONS  -------------------------------- //
 // //////////////////////////////////////////////////////////////////////////// //
 
-func (c *Client) AddLatestToPlaylist(tracks []*Track) {
+// PlaylistID : Extracts the playlist ID from a 'spotify:playlist:' URI or an 'open.spotify.com/playlist/' URL.
+// The second value is false if target is none of these. A bare ID is not accepted,
+// it can't be told apart from the name of a playlist: FindPlaylist tries it after the names.
+func PlaylistID(target string) (string, bool) {
+	var id string
+	if strings.HasPrefix(target, "spotify:playlist:") {
+		id = strings.TrimPrefix(target, "spotify:playlist:")
+	} else if u, err := url.Parse(target); err == nil && u.Host == "open.spotify.com" && strings.HasPrefix(u.Path, "/playlist/") {
+		id = strings.TrimPrefix(u.Path, "/playlist/")
+	}
+	if !playlistIDPattern.MatchString(id) {
+		return "", false
+	}
+	return id, true
+}
+
+// GetCurrentUserPlaylists : Returns all the playlists owned or followed by the current user
+func (c *Client) GetCurrentUserPlaylists() ([]SimplePlaylist, error) {
+	return c.GetCurrentUserPlaylistsContext(context.Background())
+}
+
+// GetCurrentUserPlaylistsContext : Same as GetCurrentUserPlaylists, with a context to cancel the requests
+func (c *Client) GetCurrentUserPlaylistsContext(ctx context.Context) ([]SimplePlaylist, error) {
+	return c.IterCurrentUserPlaylists(ctx).All()
+}
+
+// IterCurrentUserPlaylists : Returns an iterator over the playlists owned or followed by the current user
+func (c *Client) IterCurrentUserPlaylists(ctx context.Context) *Iterator[SimplePlaylist] {
+	v := url.Values{}
+	v.Set("limit", "50")
+	funcURL := c.BaseURL + "me/playlists?" + v.Encode()
+
+	return newIterator(ctx, funcURL, -1, offsetPages[SimplePlaylist](c))
+}
+
+// CreatePlaylist : Creates a playlist for the user.
+// Creating a private playlist requires the playlist-modify-private scope.
+func (c *Client) CreatePlaylist(userID, name, description string, public bool) (*SimplePlaylist, error) {
+	return c.CreatePlaylistContext(context.Background(), userID, name, description, public)
+}
+
+// CreatePlaylistContext : Same as CreatePlaylist, with a context to cancel the requests
+func (c *Client) CreatePlaylistContext(ctx context.Context, userID, name, description string, public bool) (*SimplePlaylist, error) {
+	funcURL := c.BaseURL + "users/{user_id}/playlists"
+	funcURL = strings.Replace(funcURL, "{user_id}", url.PathEscape(userID), -1)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"public":      public,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", funcURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var p SimplePlaylist
+	err = c.execute(req, &p, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// FindPlaylist : Returns the ID of the playlist designated by target, which is
+// a playlist URI, open.spotify.com URL, the name of one of the user's playlists or a playlist ID.
+// A target that could be both a name and an ID is first looked up as a name.
+// The second value is false when no playlist owned by the user has this name or ID.
+func (c *Client) FindPlaylist(target, userID string) (string, bool, error) {
+	return c.FindPlaylistContext(context.Background(), target, userID)
+}
+
+// FindPlaylistContext : Same as FindPlaylist, with a context to cancel the requests
+func (c *Client) FindPlaylistContext(ctx context.Context, target, userID string) (string, bool, error) {
+	if id, ok := PlaylistID(target); ok {
+		return id, true, nil
+	}
+
+	it := c.IterCurrentUserPlaylists(ctx)
+	for it.Next() {
+		// Followed playlists of other users cannot be modified
+		if p := it.Item(); p.Name == target && p.Owner.ID == userID {
+			return p.ID, true, nil
+		}
+	}
+	if err := it.Err(); err != nil || !playlistIDPattern.MatchString(target) {
+		return "", false, err
+	}
+
+	p, err := c.GetPlaylistContext(ctx, target)
+	if errors.Is(err, ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return p.ID, true, nil
+}
+
+// ResolvePlaylist : Returns the ID of the playlist designated by target, like FindPlaylist.
+// When no playlist owned by the user has this name, a private one is created with the description.
+func (c *Client) ResolvePlaylist(target, userID, description string) (string, error) {
+	return c.ResolvePlaylistContext(context.Background(), target, userID, description)
+}
+
+// ResolvePlaylistContext : Same as ResolvePlaylist, with a context to cancel the requests
+func (c *Client) ResolvePlaylistContext(ctx context.Context, target, userID, description string) (string, error) {
+	id, found, err := c.FindPlaylistContext(ctx, target, userID)
+	if err != nil || found {
+		return id, err
+	}
+
+	p, err := c.CreatePlaylistContext(ctx, userID, target, description, false)
+	if err != nil {
+		return "", err
+	}
+	return p.ID, nil
+}
+
+// GetPlaylist : Returns the playlist without its tracks, see GetPlaylistTracks
+func (c *Client) GetPlaylist(playlistID string) (*SimplePlaylist, error) {
+	return c.GetPlaylistContext(context.Background(), playlistID)
+}
+
+// GetPlaylistContext : Same as GetPlaylist, with a context to cancel the requests
+func (c *Client) GetPlaylistContext(ctx context.Context, playlistID string) (*SimplePlaylist, error) {
+	v := url.Values{}
+	v.Set("fields", "collaborative,description,external_urls,href,id,images,name,owner,public,snapshot_id,uri")
+	funcURL := c.BaseURL + "playlists/{playlist_id}"
+	funcURL = strings.Replace(funcURL, "{playlist_id}", playlistID, -1) + "?" + v.Encode()
+
+	var p SimplePlaylist
+	if err := c.get(ctx, funcURL, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetPlaylistTracks : Returns all the items of the playlist with the time they were added
+func (c *Client) GetPlaylistTracks(playlistID string) ([]PlaylistTrack, error) {
+	return c.GetPlaylistTracksContext(context.Background(), playlistID)
+}
+
+// GetPlaylistTracksContext : Same as GetPlaylistTracks, with a context to cancel the requests
+func (c *Client) GetPlaylistTracksContext(ctx context.Context, playlistID string) ([]PlaylistTrack, error) {
+	return c.IterPlaylistTracks(ctx, playlistID).All()
+}
+
+// IterPlaylistTracks : Returns an iterator over the items of the playlist
+func (c *Client) IterPlaylistTracks(ctx context.Context, playlistID string) *Iterator[PlaylistTrack] {
+	v := url.Values{}
+	v.Set("limit", "100")
+	funcURL := c.BaseURL + "playlists/{playlist_id}/tracks"
+	funcURL = strings.Replace(funcURL, "{playlist_id}", playlistID, -1) + "?" + v.Encode()
+
+	return newIterator(ctx, funcURL, -1, offsetPages[PlaylistTrack](c))
+}
+
+// TrackPositions : A track of a playlist and the positions it is at, counted from 0
+type TrackPositions struct {
+	URI       string `json:"uri"`
+	Positions []int  `json:"positions"`
+}
+
+// RemoveTracksFromPlaylist : Removes the tracks at their positions in the version snapshotID of the playlist,
+// the other occurrences of the same tracks stay. The positions are removed 100 at a time starting from
+// the end of the playlist, so a batch never moves the tracks of the next ones.
+// Returns the snapshot ID of the playlist after the last removal.
+func (c *Client) RemoveTracksFromPlaylist(playlistID, snapshotID string, tracks []TrackPositions) (string, error) {
+	return c.RemoveTracksFromPlaylistContext(context.Background(), playlistID, snapshotID, tracks)
+}
+
+// RemoveTracksFromPlaylistContext : Same as RemoveTracksFromPlaylist, with a context to cancel the requests
+func (c *Client) RemoveTracksFromPlaylistContext(ctx context.Context, playlistID, snapshotID string, tracks []TrackPositions) (string, error) {
 	funcURL := c.BaseURL + "playlists/{playlist_id}/tracks"
-	funcURL = strings.Replace(funcURL, "{playlist_id}", _playlistNewNewID, -1)
+	funcURL = strings.Replace(funcURL, "{playlist_id}", playlistID, -1)
 
-	var reqTracks = []string{}
-	for i := 1; i <= len(tracks); i++ {
-		reqTracks = append(reqTracks, tracks[i-1].URI)
-		if (i%100) == 0 || i == (len(tracks)) {
-			AddToPlaylist(reqTracks, funcURL, c)
-			reqTracks = nil
+	type position struct {
+		uri string
+		pos int
+	}
+	var positions []position
+	for _, t := range tracks {
+		for _, pos := range t.Positions {
+			positions = append(positions, position{uri: t.URI, pos: pos})
 		}
 	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].pos > positions[j].pos })
+
+	for start := 0; start < len(positions); start += 100 {
+		end := start + 100
+		if end > len(positions) {
+			end = len(positions)
+		}
+		var batch []TrackPositions
+		index := map[string]int{}
+		for _, p := range positions[start:end] {
+			i, ok := index[p.uri]
+			if !ok {
+				i = len(batch)
+				index[p.uri] = i
+				batch = append(batch, TrackPositions{URI: p.uri})
+			}
+			batch[i].Positions = append(batch[i].Positions, p.pos)
+		}
+
+		body, err := json.Marshal(map[string]interface{}{"tracks": batch, "snapshot_id": snapshotID})
+		if err != nil {
+			return snapshotID, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "DELETE", funcURL, bytes.NewReader(body))
+		if err != nil {
+			return snapshotID, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		result := struct {
+			SnapshotID string `json:"snapshot_id"`
+		}{}
+		err = c.execute(req, &result)
+		if err != nil {
+			return snapshotID, err
+		}
+		// The next positions are in the version of the playlist without this batch
+		snapshotID = result.SnapshotID
+	}
+	return snapshotID, nil
+}
+
+// BatchError : Failure of a batch of a playlist modification, which stops the next batches
+type BatchError struct {
+	// Offset is the position of the first track of the batch in the tracks given
+	Offset int
+	// URIs are the tracks of the batch and of the next ones, none of them made it
+	URIs []string
+	Err  error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("spotify: %d tracks from offset %d: %v", len(e.URIs), e.Offset, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// PartialAddError : Returned when the first batches of tracks were added to the playlist and then one failed
+type PartialAddError struct {
+	// SnapshotID is the version of the playlist after the last successful batch
+	SnapshotID string
+	// Added is the number of tracks added, the first ones given
+	Added int
+	// Failed holds the failed batch with all the tracks after it
+	Failed []*BatchError
+}
+
+func (e *PartialAddError) Error() string {
+	failed := 0
+	for _, b := range e.Failed {
+		failed += len(b.URIs)
+	}
+	return fmt.Sprintf("spotify: added %d tracks to the playlist but %d failed: %v", e.Added, failed, e.Failed[0].Err)
+}
+
+// Unwrap : Returns the error of the first failed batch
+func (e *PartialAddError) Unwrap() error {
+	return e.Failed[0]
+}
+
+// FailedURIs : Returns the tracks that were not added
+func (e *PartialAddError) FailedURIs() []string {
+	var uris []string
+	for _, b := range e.Failed {
+		uris = append(uris, b.URIs...)
+	}
+	return uris
+}
+
+// AddTracksToPlaylist : Adds the tracks to the end of the playlist, 100 at a time.
+// Returns the snapshot ID of the playlist after the last batch.
+// A failed batch stops the next ones, so the tracks are never added out of order. The error is
+// a *PartialAddError when some batches were added before, a *BatchError holding every track otherwise.
+func (c *Client) AddTracksToPlaylist(playlistID string, uris []string) (string, error) {
+	return c.AddTracksToPlaylistContext(context.Background(), playlistID, uris)
+}
+
+// AddTracksToPlaylistContext : Same as AddTracksToPlaylist, with a context to cancel the requests
+func (c *Client) AddTracksToPlaylistContext(ctx context.Context, playlistID string, uris []string) (string, error) {
+	funcURL := c.BaseURL + "playlists/{playlist_id}/tracks"
+	funcURL = strings.Replace(funcURL, "{playlist_id}", playlistID, -1)
+
+	partial := &PartialAddError{}
+	for start := 0; start < len(uris); start += 100 {
+		end := start + 100
+		if end > len(uris) {
+			end = len(uris)
+		}
+		err := ctx.Err()
+		if err == nil {
+			var snapshotID string
+			if snapshotID, err = addToPlaylist(ctx, uris[start:end], funcURL, c); err == nil {
+				partial.SnapshotID = snapshotID
+				partial.Added += end - start
+				continue
+			}
+		}
+		failed := &BatchError{Offset: start, URIs: uris[start:], Err: err}
+		if partial.Added == 0 {
+			return "", failed
+		}
+		partial.Failed = []*BatchError{failed}
+		return partial.SnapshotID, partial
+	}
+	return partial.SnapshotID, nil
+}
+
+// AddLatestToPlaylist : Adds the tracks to the playlist, 100 at a time, see AddTracksToPlaylist
+func (c *Client) AddLatestToPlaylist(playlistID string, tracks []*Track) (string, error) {
+	return c.AddLatestToPlaylistContext(context.Background(), playlistID, tracks)
+}
+
+// AddLatestToPlaylistContext : Same as AddLatestToPlaylist, with a context to cancel the requests
+func (c *Client) AddLatestToPlaylistContext(ctx context.Context, playlistID string, tracks []*Track) (string, error) {
+	var reqTracks = make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		reqTracks = append(reqTracks, t.URI)
+	}
+	return c.AddTracksToPlaylistContext(ctx, playlistID, reqTracks)
+}
 
+// AddToPlaylist : Adds up to 100 tracks to the playlist at funcURL, its tracks endpoint.
+// Returns the snapshot ID of the playlist after the addition.
+func AddToPlaylist(tracks []string, funcURL string, c *Client) (string, error) {
+	return addToPlaylist(context.Background(), tracks, funcURL, c)
 }
 
-func AddToPlaylist(tracks []string, funcURL string, c *Client) {
+func addToPlaylist(ctx context.Context, tracks []string, funcURL string, c *Client) (string, error) {
 	m := make(map[string]interface{})
 	m["uris"] = tracks
 	body, err := json.Marshal(m)
-	os.Stdout.Write(body)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	req, err := http.NewRequest("POST", funcURL, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", funcURL, bytes.NewReader(body))
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -50,6 +405,7 @@ func AddToPlaylist(tracks []string, funcURL string, c *Client) {
 
 	err = c.execute(req, &result, http.StatusCreated)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
+	return result.SnapshotID, nil
 }
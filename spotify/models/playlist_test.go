@@ -0,0 +1,244 @@
+package models_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+func TestPlaylistID(t *testing.T) {
+	const id = "37i9dQZF1DXcBWIGoYBM5M"
+	tests := []struct {
+		target string
+		want   string
+		ok     bool
+	}{
+		{"spotify:playlist:" + id, id, true},
+		{"https://open.spotify.com/playlist/" + id, id, true},
+		{"https://open.spotify.com/playlist/" + id + "?si=abc", id, true},
+		// A bare ID could be the name of a playlist, FindPlaylist tries the names first
+		{id, "", false},
+		{"New Releases", "", false},
+		{"https://open.spotify.com/album/" + id, "", false},
+		{"https://example.com/playlist/" + id, "", false},
+		{"spotify:playlist:tooShort", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := models.PlaylistID(tt.target)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("PlaylistID(%q) = %q, %v, want %q, %v", tt.target, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFindPlaylist(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	named := s.AddPlaylist("New Releases")
+	// The name of this playlist looks like an ID
+	idLike := s.AddPlaylist("ABCDEFGHIJKLMNOPQRSTUV")
+
+	tests := []struct {
+		target string
+		want   string
+		found  bool
+	}{
+		{"New Releases", named, true},
+		{"ABCDEFGHIJKLMNOPQRSTUV", idLike, true},
+		{"spotify:playlist:" + named, named, true},
+		{"https://open.spotify.com/playlist/" + idLike, idLike, true},
+		// No playlist has these names, they are IDs
+		{named, named, true},
+		{idLike, idLike, true},
+		{"0000000000000000009999", "", false},
+		{"Missing", "", false},
+	}
+	for _, tt := range tests {
+		got, found, err := c.FindPlaylist(tt.target, "spotifytest")
+		if err != nil {
+			t.Fatalf("FindPlaylist(%q): %v", tt.target, err)
+		}
+		if got != tt.want || found != tt.found {
+			t.Errorf("FindPlaylist(%q) = %q, %v, want %q, %v", tt.target, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestRemoveTracksFromPlaylist(t *testing.T) {
+	// 150 tracks: a, b, then c 148 times
+	uris := []string{"spotify:track:a", "spotify:track:b"}
+	for i := 0; i < 148; i++ {
+		uris = append(uris, "spotify:track:c")
+	}
+	var everyC []int
+	for i := 2; i < 150; i++ {
+		everyC = append(everyC, i)
+	}
+	tests := []struct {
+		name   string
+		remove []models.TrackPositions
+		want   []string
+	}{
+		{
+			name:   "only the given occurrence",
+			remove: []models.TrackPositions{{URI: "spotify:track:c", Positions: []int{2}}},
+			want:   uris[:149],
+		},
+		{
+			name: "several tracks",
+			remove: []models.TrackPositions{
+				{URI: "spotify:track:a", Positions: []int{0}},
+				{URI: "spotify:track:c", Positions: []int{3, 149}},
+			},
+			want: uris[1:148],
+		},
+		{
+			name:   "over several batches",
+			remove: []models.TrackPositions{{URI: "spotify:track:c", Positions: everyC}},
+			want:   uris[:2],
+		},
+		{
+			name:   "nothing",
+			remove: nil,
+			want:   uris,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := spotifytest.NewServer()
+			defer s.Close()
+			c := s.Client()
+			id := s.AddPlaylist("Releases", uris...)
+			p, err := c.GetPlaylist(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.RemoveTracksFromPlaylist(id, p.SnapshotID, tt.remove); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.PlaylistTracks(id); !equal(got, tt.want) {
+				t.Errorf("playlist holds %d tracks, want %d: %v", len(got), len(tt.want), got)
+			}
+		})
+	}
+}
+
+func TestRemoveTracksFromPlaylistWrongPosition(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	id := s.AddPlaylist("Releases", "spotify:track:a", "spotify:track:b")
+	p, err := c.GetPlaylist(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.RemoveTracksFromPlaylist(id, p.SnapshotID, []models.TrackPositions{{URI: "spotify:track:a", Positions: []int{1}}})
+	if err == nil {
+		t.Fatal("removing a track at the position of another one succeeded")
+	}
+	if got := s.PlaylistTracks(id); len(got) != 2 {
+		t.Errorf("playlist holds %v, want both tracks", got)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAddTracksToPlaylist(t *testing.T) {
+	var uris []string
+	for i := 0; i < 250; i++ {
+		uris = append(uris, fmt.Sprintf("spotify:track:%d", i))
+	}
+	tests := []struct {
+		name string
+		// successfulAdds is the number of batches added before they fail, -1 for none
+		successfulAdds int
+		wantAdded      int
+		wantPartial    bool
+		wantBatch      bool
+	}{
+		{name: "every batch", successfulAdds: -1, wantAdded: 250},
+		{name: "first batch failed", successfulAdds: 0, wantAdded: 0, wantBatch: true},
+		{name: "second batch failed", successfulAdds: 1, wantAdded: 100, wantPartial: true},
+		{name: "last batch failed", successfulAdds: 2, wantAdded: 200, wantPartial: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := spotifytest.NewServer()
+			defer s.Close()
+			c := s.Client()
+			if tt.successfulAdds >= 0 {
+				s.FailAfter("POST", tt.successfulAdds, http.StatusInternalServerError, 1)
+			}
+			id := s.AddPlaylist("Releases")
+
+			_, err := c.AddTracksToPlaylist(id, uris)
+			// The tracks added are always the first ones, in order
+			if got := s.PlaylistTracks(id); !equal(got, uris[:tt.wantAdded]) {
+				t.Errorf("playlist holds %d tracks, want the first %d", len(got), tt.wantAdded)
+			}
+
+			var partial *models.PartialAddError
+			var batch *models.BatchError
+			switch {
+			case tt.wantPartial:
+				if !errors.As(err, &partial) {
+					t.Fatalf("error = %v, want a *PartialAddError", err)
+				}
+				if partial.Added != tt.wantAdded || !equal(partial.FailedURIs(), uris[tt.wantAdded:]) {
+					t.Errorf("PartialAddError says %d added and %d failed, want %d and %d",
+						partial.Added, len(partial.FailedURIs()), tt.wantAdded, len(uris)-tt.wantAdded)
+				}
+			case tt.wantBatch:
+				if !errors.As(err, &batch) || errors.As(err, &partial) {
+					t.Fatalf("error = %v, want a *BatchError", err)
+				}
+				if !equal(batch.URIs, uris) || !errors.Is(err, models.ErrServerError) {
+					t.Errorf("BatchError holds %d tracks and %v, want all of them and a server error", len(batch.URIs), batch.Err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestIterPlaylistTracks(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	var uris []string
+	for i := 0; i < 250; i++ {
+		uris = append(uris, fmt.Sprintf("spotify:track:%d", i))
+	}
+	id := s.AddPlaylist("Releases", uris...)
+
+	items, err := c.GetPlaylistTracks(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(items))
+	for _, item := range items {
+		got = append(got, item.Track.URI)
+	}
+	// Three pages of 100 tracks
+	if !equal(got, uris) || s.Requests() != 3 {
+		t.Errorf("read %d tracks in %d requests, want 250 in 3", len(got), s.Requests())
+	}
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// slowDownInterval is the minimum time between two slow downs,
+	// so a burst of concurrent 429s only halves the rate once
+	slowDownInterval = time.Second
+	// recoveryDelay is how long the limiter waits after a slow down before speeding up again
+	recoveryDelay = 30 * time.Second
+	// recoveryDuration is the time it takes to go back from the minimum to the configured rate
+	recoveryDuration = time.Minute
+)
+
+// RateLimiter : Token bucket limiting the requests sent by a client, shared by all the goroutines
+// using it. It slows down when the server answers 429 Too Many Requests and
+// gets back to its configured rate once the server stops complaining.
+type RateLimiter struct {
+	mu sync.Mutex
+	// Configured and current number of requests per second
+	maxRate, rate float64
+	burst         float64
+	// Available tokens, negative when requests are waiting for theirs
+	tokens       float64
+	last         time.Time
+	lastSlowDown time.Time
+	stats        RateLimiterStats
+}
+
+// RateLimiterStats : What the limiter did since it was created
+type RateLimiterStats struct {
+	// Requests is the number of requests that went through the limiter
+	Requests int64
+	// Waits is the number of requests that had to wait
+	Waits int64
+	// Waited is the total time spent waiting, summed over all the requests
+	Waited time.Duration
+	// SlowDowns is the number of times the rate was lowered after a 429
+	SlowDowns int64
+	// Rate is the current number of requests per second
+	Rate float64
+}
+
+func (s RateLimiterStats) String() string {
+	return fmt.Sprintf("%d requests, %d waited for %s in total, %d slow downs, now at %.1f requests/s",
+		s.Requests, s.Waits, s.Waited.Round(time.Millisecond), s.SlowDowns, s.Rate)
+}
+
+// NewRateLimiter : Creates a limiter allowing ratePerSecond requests per second on average
+// and bursts of up to burst requests. A rate of 0 or less does not limit anything.
+func NewRateLimiter(ratePerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		maxRate: ratePerSecond,
+		rate:    ratePerSecond,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+	}
+}
+
+// Wait : Blocks until the request is allowed to be sent, or the context is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l.maxRate <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.refill(now)
+	l.stats.Requests++
+	// Take the token even if it is not there yet, the next callers wait for it too
+	l.tokens--
+	if l.tokens >= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.stats.Waits++
+	l.stats.Waited += wait
+	l.mu.Unlock()
+
+	if err := sleep(ctx, wait); err != nil {
+		// Give the token back, the request is not sent
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// SlowDown : Halves the rate, called when the server answers 429 Too Many Requests
+func (l *RateLimiter) SlowDown() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastSlowDown) < slowDownInterval {
+		return
+	}
+	l.refill(now)
+	l.lastSlowDown = now
+	l.rate = math.Max(l.rate/2, l.minRate())
+	l.stats.SlowDowns++
+}
+
+// Stats : Returns what the limiter did so far
+func (l *RateLimiter) Stats() RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	stats := l.stats
+	stats.Rate = l.rate
+	return stats
+}
+
+// refill adds the tokens earned since the last call and speeds back up
+// if the last slow down is old enough. l.mu must be held.
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	l.last = now
+	l.tokens = math.Min(l.tokens+elapsed*l.rate, l.burst)
+
+	if l.rate < l.maxRate && now.Sub(l.lastSlowDown) > recoveryDelay {
+		l.rate = math.Min(l.rate+elapsed*(l.maxRate-l.minRate())/recoveryDuration.Seconds(), l.maxRate)
+	}
+}
+
+// minRate is the lowest rate the limiter slows down to
+func (l *RateLimiter) minRate() float64 {
+	return l.maxRate / 16
+}
@@ -0,0 +1,94 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    int
+		requests int
+		// The requests can't be sent faster than minElapsed, nor slower than maxElapsed
+		minElapsed, maxElapsed time.Duration
+		wantWaits              int64
+	}{
+		{name: "no limit", rate: 0, burst: 1, requests: 10, maxElapsed: 500 * time.Millisecond},
+		{name: "within the burst", rate: 2, burst: 5, requests: 5, maxElapsed: 400 * time.Millisecond},
+		{name: "spaced out", rate: 50, burst: 1, requests: 6, minElapsed: 100 * time.Millisecond, maxElapsed: time.Second, wantWaits: 5},
+		{name: "spaced out after the burst", rate: 50, burst: 3, requests: 6, minElapsed: 60 * time.Millisecond, maxElapsed: time.Second, wantWaits: 3},
+	}
+	for _, tt := range tests {
+		s := spotifytest.NewServer()
+		defer s.Close()
+		c := s.Client()
+		c.Limiter = models.NewRateLimiter(tt.rate, tt.burst)
+
+		start := time.Now()
+		for i := 0; i < tt.requests; i++ {
+			if _, err := c.CurrentUser(); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		elapsed := time.Since(start)
+		if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+			t.Errorf("%s: %d requests took %s, want between %s and %s", tt.name, tt.requests, elapsed, tt.minElapsed, tt.maxElapsed)
+		}
+		if tt.rate <= 0 {
+			continue
+		}
+		stats := c.Limiter.Stats()
+		if stats.Requests != int64(tt.requests) || stats.Waits != tt.wantWaits {
+			t.Errorf("%s: stats = %d requests and %d waits, want %d and %d", tt.name, stats.Requests, stats.Waits, tt.requests, tt.wantWaits)
+		}
+	}
+}
+
+func TestRateLimiterSlowDown(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	c.Limiter = models.NewRateLimiter(100, 10)
+
+	// Two 429s in a row only halve the rate once
+	s.RateLimit(2, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := c.CurrentUser(); !errors.Is(err, models.ErrRateLimited) {
+			t.Fatalf("err = %v, want ErrRateLimited", err)
+		}
+	}
+	stats := c.Limiter.Stats()
+	if stats.SlowDowns != 1 || stats.Rate != 50 {
+		t.Errorf("after two 429s: %d slow downs at %.1f requests/s, want 1 at 50", stats.SlowDowns, stats.Rate)
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	l := models.NewRateLimiter(4, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next token is 250ms away
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The cancelled request gave its token back, the next one only waits for the first
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("waited %s after a cancelled request, want at most 250ms", elapsed)
+	}
+}
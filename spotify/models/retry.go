@@ -0,0 +1,166 @@
+package models
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy : Decides whether a failed request is sent again and how long to wait before.
+// The wait asked by a Retry-After header is always honored, otherwise it grows exponentially
+// from BaseDelay up to MaxDelay, randomized by Jitter so concurrent requests don't retry together.
+// A request that may have been carried out is only sent again when its method is idempotent:
+// the POST requests, like the additions to a playlist, are only retried when rate limited
+// unless RetryNonIdempotent is set.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is sent, including the first one.
+	// 0 means no limit.
+	MaxAttempts int
+	// MaxElapsed gives up when the next attempt would start later than this after the first one.
+	// 0 means no limit.
+	MaxElapsed time.Duration
+	// BaseDelay is the wait before the first retry, doubled at each attempt
+	BaseDelay time.Duration
+	// MaxDelay caps the exponential wait, not the one asked by Retry-After
+	MaxDelay time.Duration
+	// Jitter is the fraction of the wait that is randomized, between 0 and 1
+	Jitter float64
+	// RetryStatuses are the HTTP status codes worth retrying
+	RetryStatuses []int
+	// RetryNonIdempotent also retries the POST requests that failed with a server error
+	// or without a response, at the risk of carrying them out twice, like adding tracks twice
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy : The policy used by clients with AutoRetry set.
+// It retries rate limited requests, transient server errors and network errors
+// up to 5 times within 2 minutes.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 5,
+		MaxElapsed:  2 * time.Minute,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.5,
+		RetryStatuses: []int{
+			rateLimitExceededStatusCode,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// retryPolicy returns the policy of the client, nil when failed requests are not retried
+func (c *Client) retryPolicy() *RetryPolicy {
+	if c.Retry != nil {
+		return c.Retry
+	}
+	if c.AutoRetry {
+		return DefaultRetryPolicy()
+	}
+	return nil
+}
+
+// retryable reports whether the response status is worth retrying for the request
+func (p *RetryPolicy) retryable(req *http.Request, status int) bool {
+	// A rate limited request was not carried out, it is safe to send again whatever its method
+	if status != rateLimitExceededStatusCode && !p.RetryNonIdempotent && !idempotent(req.Method) {
+		return false
+	}
+	for _, s := range p.RetryStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableError reports whether the request that failed without a response is worth retrying
+func (p *RetryPolicy) retryableError(req *http.Request) bool {
+	return req.Context().Err() == nil && (p.RetryNonIdempotent || idempotent(req.Method))
+}
+
+// idempotent reports whether sending a request with the method twice has the same effect as once
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// next returns how long to wait before the attempt following `attempt` (starting at 1),
+// and false when the policy gives up. elapsed is the time spent since the first attempt,
+// resp is nil when the attempt failed without a response.
+func (p *RetryPolicy) next(attempt int, elapsed time.Duration, resp *http.Response) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	var wait time.Duration
+	if resp != nil {
+		wait = retryAfter(resp)
+	}
+	if wait == 0 {
+		if resp != nil && resp.StatusCode == rateLimitExceededStatusCode {
+			// The server sometimes asks to retry without telling when
+			wait = defaultRetryDuration
+		} else {
+			wait = p.backoff(attempt)
+		}
+	}
+
+	if p.MaxElapsed > 0 && elapsed+wait > p.MaxElapsed {
+		return 0, false
+	}
+	return wait, true
+}
+
+// backoff returns the randomized exponential wait after the attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	wait := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && wait > float64(p.MaxDelay) {
+		wait = float64(p.MaxDelay)
+	}
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+	return time.Duration(wait * (1 - jitter*rand.Float64()))
+}
+
+// AttemptCounter : Counts the requests made with a context and the times they were sent,
+// retries included, see WithAttemptCounter. It can be shared by concurrent requests.
+type AttemptCounter struct {
+	requests atomic.Int64
+	attempts atomic.Int64
+}
+
+type attemptCounterKey struct{}
+
+// WithAttemptCounter : Returns a context counting the attempts of the requests made with it,
+// successful or not. The failed ones also tell their attempts in Error and RequestError.
+func WithAttemptCounter(ctx context.Context) (context.Context, *AttemptCounter) {
+	counter := &AttemptCounter{}
+	return context.WithValue(ctx, attemptCounterKey{}, counter), counter
+}
+
+// Requests : Returns the number of requests sent
+func (a *AttemptCounter) Requests() int {
+	return int(a.requests.Load())
+}
+
+// Attempts : Returns the number of times the requests were sent, at least Requests
+func (a *AttemptCounter) Attempts() int {
+	return int(a.attempts.Load())
+}
+
+// count records a request sent attempts times in the counter of the context, if any
+func count(ctx context.Context, attempts int) {
+	if a, ok := ctx.Value(attemptCounterKey{}).(*AttemptCounter); ok && attempts > 0 {
+		a.requests.Add(1)
+		a.attempts.Add(int64(attempts))
+	}
+}
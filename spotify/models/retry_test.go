@@ -0,0 +1,104 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		// post adds a track to a playlist instead of getting the user
+		post           bool
+		failures       int
+		status         int
+		nonIdempotent  bool
+		maxAttempts    int
+		wantAttempts   int
+		wantErr        bool
+		wantStatus     int
+		wantRequestErr bool
+	}{
+		{name: "GET server errors", failures: 2, status: 500, wantAttempts: 3},
+		{name: "GET network errors", failures: 2, status: 0, wantAttempts: 3},
+		{name: "GET not found", failures: 1, status: 404, wantAttempts: 1, wantErr: true, wantStatus: 404},
+		{name: "GET gives up", failures: 5, status: 502, maxAttempts: 3, wantAttempts: 3, wantErr: true, wantStatus: 502},
+		{name: "GET network gives up", failures: 5, status: 0, maxAttempts: 2, wantAttempts: 2, wantErr: true, wantRequestErr: true},
+		{name: "POST server error", post: true, failures: 1, status: 500, wantAttempts: 1, wantErr: true, wantStatus: 500},
+		{name: "POST network error", post: true, failures: 1, status: 0, wantAttempts: 1, wantErr: true, wantRequestErr: true},
+		{name: "POST rate limited", post: true, failures: 1, status: 429, wantAttempts: 2},
+		{name: "POST server error opted in", post: true, failures: 1, status: 500, nonIdempotent: true, wantAttempts: 2},
+		{name: "POST network error opted in", post: true, failures: 1, status: 0, nonIdempotent: true, wantAttempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := spotifytest.NewServer()
+			defer s.Close()
+			id := s.AddPlaylist("Releases")
+			c := s.Client()
+			c.Retry = models.DefaultRetryPolicy()
+			c.Retry.BaseDelay = time.Millisecond
+			c.Retry.RetryNonIdempotent = tt.nonIdempotent
+			if tt.maxAttempts > 0 {
+				c.Retry.MaxAttempts = tt.maxAttempts
+			}
+			s.FailNext("", tt.status, tt.failures)
+
+			ctx, counter := models.WithAttemptCounter(context.Background())
+			var err error
+			if tt.post {
+				_, err = c.AddTracksToPlaylistContext(ctx, id, []string{"spotify:track:a"})
+			} else {
+				_, err = c.CurrentUserContext(ctx)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if counter.Requests() != 1 || counter.Attempts() != tt.wantAttempts {
+				t.Errorf("counted %d requests sent %d times, want 1 sent %d times", counter.Requests(), counter.Attempts(), tt.wantAttempts)
+			}
+
+			var apiErr models.Error
+			var reqErr *models.RequestError
+			switch {
+			case tt.wantStatus != 0:
+				if !errors.As(err, &apiErr) || apiErr.Status != tt.wantStatus || apiErr.Attempts != tt.wantAttempts {
+					t.Errorf("error = %#v, want status %d after %d attempts", err, tt.wantStatus, tt.wantAttempts)
+				}
+			case tt.wantRequestErr:
+				if !errors.As(err, &reqErr) || reqErr.Attempts != tt.wantAttempts {
+					t.Errorf("error = %#v, want a RequestError after %d attempts", err, tt.wantAttempts)
+				}
+			}
+			if tt.post {
+				added := len(s.PlaylistTracks(id))
+				if want := map[bool]int{true: 0, false: 1}[tt.wantErr]; added != want {
+					t.Errorf("the track was added %d times, want %d", added, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	c.AutoRetry = true
+	s.FailNext("", http.StatusServiceUnavailable, 1)
+
+	// The Retry-After of 1s is interrupted
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.CurrentUserContext(ctx)
+	var reqErr *models.RequestError
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &reqErr) || reqErr.Attempts != 1 {
+		t.Errorf("error = %v, want the deadline after 1 attempt", err)
+	}
+}
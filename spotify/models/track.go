@@ -1,12 +1,25 @@
 package models
 
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
 type Track struct {
-	Artists []Artist `json:"artists"`
+	// The album on which the track appears.
+	// The API only sends it for the tracks of a playlist or a track lookup, not for an album's tracks:
+	// set it yourself when the album is known, like the release pipeline does.
+	Album   *SimplifiedAlbumObject `json:"album,omitempty"`
+	Artists []Artist               `json:"artists"`
 	// The markets in which the album is available
 	AvailableMarkets []string `json:"available_markets"`
 	DiscNumber       int      `json:"disc_number"`
 	Duration         int      `json:"duration_ms"`
 	Explicit         bool     `json:"explicit"`
+	// Known external IDs for this track, like its "isrc".
+	// Only set when the track comes from a playlist or a track lookup.
+	ExternalIDs map[string]string `json:"external_ids"`
 	// Known external URLs for this track
 	ExternalURLs map[string]string `json:"external_urls"`
 	// A link to the Web API endpoint providing full details of the album
@@ -24,6 +37,17 @@ type Track struct {
 	Type string `json:"type"`
 }
 
+// PlaylistTrack : A track of a playlist along with when and by whom it was added
+type PlaylistTrack struct {
+	// The date and time the track was added, use TimestampLayout to parse it.
+	// It is empty for very old playlists.
+	AddedAt string `json:"added_at"`
+	// The user who added the track
+	AddedBy User `json:"added_by"`
+	// The track, nil when it is no longer available
+	Track *Track `json:"track"`
+}
+
 type LinkedTrack struct {
 	// Known external URLs for this track
 	ExternalURLs map[string]string `json:"external_urls"`
@@ -40,3 +64,33 @@ type LinkedTrack struct {
 // ////////////////////////////////////////////////////////////////////////////// //
 // --------------------------------  FUNCTIONS  -------------------------------- //
 // //////////////////////////////////////////////////////////////////////////// //
+
+// GetTracks : Returns the full details of the tracks, including their album and external IDs.
+// The tracks are looked up 50 at a time, in the order of ids.
+func (c *Client) GetTracks(ids []string) ([]*Track, error) {
+	return c.GetTracksContext(context.Background(), ids)
+}
+
+// GetTracksContext : Same as GetTracks, with a context to cancel the requests
+func (c *Client) GetTracksContext(ctx context.Context, ids []string) ([]*Track, error) {
+	var tracks []*Track
+	for start := 0; start < len(ids); start += 50 {
+		end := start + 50
+		if end > len(ids) {
+			end = len(ids)
+		}
+		v := url.Values{}
+		v.Set("ids", strings.Join(ids[start:end], ","))
+		funcURL := c.BaseURL + "tracks?" + v.Encode()
+
+		var res struct {
+			Tracks []*Track `json:"tracks"`
+		}
+		err := c.get(ctx, funcURL, &res)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, res.Tracks...)
+	}
+	return tracks, nil
+}
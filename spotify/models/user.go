@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"net/url"
 	"strconv"
 )
@@ -65,9 +66,14 @@ type PrivateUser struct {
 
 // CurrentUser :
 func (c *Client) CurrentUser() (*PrivateUser, error) {
+	return c.CurrentUserContext(context.Background())
+}
+
+// CurrentUserContext : Same as CurrentUser, with a context to cancel the requests
+func (c *Client) CurrentUserContext(ctx context.Context) (*PrivateUser, error) {
 	var result PrivateUser
 
-	err := c.get(c.BaseURL+"me", &result)
+	err := c.get(ctx, c.BaseURL+"me", &result)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +87,11 @@ func (c *Client) CurrentUser() (*PrivateUser, error) {
 // 		(2) - The last artist ID retrieved from the previous request
 // Return : A pointer of the FullArtistCursorPage object recieved from the endpoint call
 func (c *Client) GetFollowedArtists(limit int, after string) (*FullArtistCursorPage, error) {
+	return c.GetFollowedArtistsContext(context.Background(), limit, after)
+}
+
+// GetFollowedArtistsContext : Same as GetFollowedArtists, with a context to cancel the requests
+func (c *Client) GetFollowedArtistsContext(ctx context.Context, limit int, after string) (*FullArtistCursorPage, error) {
 	funcURL := c.BaseURL + "me/following"
 
 	// Set query parameters
@@ -103,10 +114,21 @@ func (c *Client) GetFollowedArtists(limit int, after string) (*FullArtistCursorP
 		A FullArtistCursorPage `json:"artists"`
 	}
 
-	err := c.get(funcURL, &result)
+	err := c.get(ctx, funcURL, &result)
 	if err != nil {
 		return nil, err
 	}
 
 	return &result.A, nil
 }
+
+// IterFollowedArtists : Returns an iterator over all the artists followed by the current user
+func (c *Client) IterFollowedArtists(ctx context.Context) *Iterator[Artist] {
+	v := url.Values{}
+	v.Set("type", "artist")
+	v.Set("limit", "50")
+	funcURL := c.BaseURL + "me/following?" + v.Encode()
+
+	// The cursor page is under the 'artists' key, see GetFollowedArtists
+	return newIterator(ctx, funcURL, -1, cursorPages[Artist](c, "artists"))
+}
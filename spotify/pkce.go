@@ -0,0 +1,43 @@
+package spotify
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+
+	"golang.org/x/oauth2"
+)
+
+// NewPKCEAuthenticator : Returns a spotify authentificator for the Authorization Code with PKCE flow,
+// which only needs SPOTIFY_ID and no client secret, so it can be used by apps given to other people.
+// Each login started by StartLogin gets its own verifier, kept with the pending login until CompleteLogin.
+func NewPKCEAuthenticator(redirectURL string, scopes ...string) Authenticator {
+	a := newAuthenticator("", redirectURL, scopes)
+	// Without a secret the client ID is sent in the body of the token requests, refreshes included
+	a.config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	a.pkce = true
+	return a
+}
+
+// PKCE : Reports whether the authenticator uses the Authorization Code with PKCE flow
+func (a Authenticator) PKCE() bool {
+	return a.pkce
+}
+
+// generateVerifier returns a new random PKCE code verifier, to use for a single login
+func generateVerifier() (string, error) {
+	// 32 bytes give the 43 characters the verifier needs at least
+	return randomString(32)
+}
+
+// CodeChallenge : Returns the S256 code challenge of the verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authURLWithVerifier returns the consent page URL with the code challenge of the verifier
+func (a Authenticator) authURLWithVerifier(state, verifier string) string {
+	return a.config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("code_challenge", CodeChallenge(verifier)))
+}
@@ -0,0 +1,865 @@
+// Package spotifytest provides a fake of the Spotify Web API and Accounts service
+// running in process, so the clients can be used without reaching Spotify.
+package spotifytest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify"
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+// Code is the authorization code given by the fake authorize endpoint
+const Code = "spotifytest-code"
+
+// RefreshToken is the refresh token handed out by the token endpoint, the only one it accepts
+const RefreshToken = "spotifytest-refresh-token"
+
+// Server : A fake Spotify Web API and Accounts service. It serves
+// /me, /me/following, /me/playlists, /users/{id}/playlists, /artists/{id}/albums,
+// /albums/{id}/tracks, /tracks, /playlists/{id} and /playlists/{id}/tracks under /v1/,
+// and the /authorize and /api/token endpoints of the Accounts service.
+// The API requests must carry the access token handed out by the token endpoint.
+type Server struct {
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	token string
+	user  models.PrivateUser
+	// followed are the artists followed by the user, in order
+	followed []models.Artist
+	// albums of each artist and tracks of each album, by ID
+	albums map[string][]*models.SimplifiedAlbumObject
+	tracks map[string][]*models.Track
+	// tracksByURI are the tracks of every album, with their album set
+	tracksByURI map[string]*models.Track
+	playlists   []*playlist
+	// rateLimited is the number of API requests still to answer with 429 Too Many Requests
+	rateLimited int
+	retryAfter  time.Duration
+	requests    int
+	lastID      int
+	// failing is the failure injected by FailAfter, nil when the requests are answered normally
+	failing *failure
+	// challenge is the PKCE code challenge of the last authorize request, empty without PKCE
+	challenge string
+	// maxAge is the Cache-Control max-age of the GET responses
+	maxAge time.Duration
+}
+
+// failure answers n API requests with the status, once `after` of them succeeded
+type failure struct {
+	method   string
+	after, n int
+	status   int
+}
+
+type playlist struct {
+	models.SimplePlaylist
+	items []models.PlaylistTrack
+}
+
+// NewServer : Starts a fake server with a user and nothing else, see Seed and the Add methods.
+// It must be closed with Close.
+func NewServer() *Server {
+	s := &Server{
+		token:       "spotifytest-token",
+		albums:      map[string][]*models.SimplifiedAlbumObject{},
+		tracks:      map[string][]*models.Track{},
+		tracksByURI: map[string]*models.Track{},
+	}
+	s.user = models.PrivateUser{Country: "CA", Product: "premium"}
+	s.user.ID = "spotifytest"
+	s.user.DisplayName = "Spotify Test"
+	s.user.URI = "spotify:user:spotifytest"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /authorize", s.authorize)
+	mux.HandleFunc("POST /api/token", s.tokenEndpoint)
+	mux.Handle("/v1/", s.api(http.StripPrefix("/v1", s.apiRoutes())))
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) apiRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /me", s.getMe)
+	mux.HandleFunc("GET /me/following", s.getFollowing)
+	mux.HandleFunc("GET /me/playlists", s.getPlaylists)
+	mux.HandleFunc("POST /users/{id}/playlists", s.createPlaylist)
+	mux.HandleFunc("GET /artists/{id}/albums", s.getArtistAlbums)
+	mux.HandleFunc("GET /albums/{id}/tracks", s.getAlbumTracks)
+	mux.HandleFunc("GET /tracks", s.getTracks)
+	mux.HandleFunc("GET /playlists/{id}", s.getPlaylist)
+	mux.HandleFunc("GET /playlists/{id}/tracks", s.getPlaylistTracks)
+	mux.HandleFunc("POST /playlists/{id}/tracks", s.addPlaylistTracks)
+	mux.HandleFunc("DELETE /playlists/{id}/tracks", s.removePlaylistTracks)
+	return mux
+}
+
+// Close : Shuts the server down
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// URL : Returns the address of the fake Web API, to use as Client.BaseURL
+func (s *Server) URL() string {
+	return s.srv.URL + "/v1/"
+}
+
+// AuthURL : Returns the address of the fake authorize endpoint
+func (s *Server) AuthURL() string {
+	return s.srv.URL + "/authorize"
+}
+
+// TokenURL : Returns the address of the fake token endpoint
+func (s *Server) TokenURL() string {
+	return s.srv.URL + "/api/token"
+}
+
+// Token : Returns the access token accepted by the API
+func (s *Server) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// Authenticator : Returns an authenticator logging in with the fake server
+func (s *Server) Authenticator(redirectURL string, scopes ...string) spotify.Authenticator {
+	return spotify.NewAuthenticator(redirectURL, scopes...).WithEndpoints(s.AuthURL(), s.TokenURL(), s.URL())
+}
+
+// Client : Returns a client already logged in with the fake server
+func (s *Server) Client() *models.Client {
+	token := s.Token()
+	transport := s.srv.Client().Transport
+	return &models.Client{
+		Http: &http.Client{Transport: models.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set("Authorization", "Bearer "+token)
+			return transport.RoundTrip(req)
+		})},
+		BaseURL: s.URL(),
+	}
+}
+
+// Requests : Returns the number of API requests received, rate limited ones included
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// RateLimit : Answers the next n API requests with 429 Too Many Requests and a Retry-After of retryAfter
+func (s *Server) RateLimit(n int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimited, s.retryAfter = n, retryAfter
+}
+
+// FailNext : Answers the next n API requests with the method, or with any method if it is empty,
+// with the status and an API error, without carrying them out. A status of 0 breaks the connection
+// instead of answering. The 429 and 503 answers ask to retry after a second.
+func (s *Server) FailNext(method string, status, n int) {
+	s.FailAfter(method, 0, status, n)
+}
+
+// FailAfter : Same as FailNext, once the next `after` requests with the method succeeded
+func (s *Server) FailAfter(method string, after, status, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = nil
+	if n > 0 {
+		s.failing = &failure{method: method, after: after, n: n, status: status}
+	}
+}
+
+// CacheMaxAge : Lets the clients use the GET responses for d without revalidating them, 0 by default.
+// The GET responses always have an ETag and are answered with 304 Not Modified when it matches If-None-Match.
+func (s *Server) CacheMaxAge(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAge = d
+}
+
+// SetUser : Replaces the logged in user
+func (s *Server) SetUser(u models.PrivateUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = u
+}
+
+// NewID : Returns a new Spotify ID, a 22 characters base62 string
+func (s *Server) NewID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newID()
+}
+
+func (s *Server) newID() string {
+	s.lastID++
+	return fmt.Sprintf("%022d", s.lastID)
+}
+
+// FollowArtist : Adds the artist to the ones followed by the user, giving it an ID and URI if it has none.
+// Returns the artist as stored.
+func (s *Server) FollowArtist(a models.Artist) models.Artist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a.ID == "" {
+		a.ID = s.newID()
+	}
+	if a.URI == "" {
+		a.URI = "spotify:artist:" + a.ID
+	}
+	if a.Type == "" {
+		a.Type = "artist"
+	}
+	s.followed = append(s.followed, a)
+	return a
+}
+
+// AddAlbum : Adds the album with its tracks to the albums of the artist, giving them IDs and URIs
+// if they have none. The album artists default to the artist, and the track artists to the album ones.
+// Returns the album as stored.
+func (s *Server) AddAlbum(artist models.Artist, album models.SimplifiedAlbumObject, tracks ...models.Track) *models.SimplifiedAlbumObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := &album
+	if a.ID == "" {
+		a.ID = s.newID()
+	}
+	if a.URI == "" {
+		a.URI = "spotify:album:" + a.ID
+	}
+	if a.Type == "" {
+		a.Type = "album"
+	}
+	if a.AlbumType == "" {
+		a.AlbumType = "album"
+	}
+	if a.AlbumGroup == "" {
+		a.AlbumGroup = a.AlbumType
+	}
+	if a.ReleaseDatePrecision == "" {
+		a.ReleaseDatePrecision = a.ReleaseDate.Precision
+	}
+	if len(a.Artists) == 0 {
+		a.Artists = []models.Artist{artist}
+	}
+	a.TotalTracks = len(tracks)
+
+	for i := range tracks {
+		t := tracks[i]
+		if t.ID == "" {
+			t.ID = s.newID()
+		}
+		if t.URI == "" {
+			t.URI = "spotify:track:" + t.ID
+		}
+		if t.Type == "" {
+			t.Type = "track"
+		}
+		if len(t.Artists) == 0 {
+			t.Artists = a.Artists
+		}
+		t.TrackNum = i + 1
+		s.tracks[a.ID] = append(s.tracks[a.ID], &t)
+		full := t
+		full.Album = a
+		s.tracksByURI[t.URI] = &full
+	}
+	s.albums[artist.ID] = append(s.albums[artist.ID], a)
+	return a
+}
+
+// AddPlaylist : Creates a playlist owned by the user holding the tracks, returns its ID
+func (s *Server) AddPlaylist(name string, uris ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.newPlaylist(name, "", false)
+	p.add(uris, s.track, time.Now())
+	return p.ID
+}
+
+// PlaylistTracks : Returns the URIs of the tracks of the playlist, in order
+func (s *Server) PlaylistTracks(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.playlist(id)
+	if p == nil {
+		return nil
+	}
+	uris := make([]string, 0, len(p.items))
+	for _, item := range p.items {
+		uris = append(uris, item.Track.URI)
+	}
+	return uris
+}
+
+// Seed : Adds a small catalog: two followed artists with an album released a week ago,
+// a single released a year ago and a single released yesterday, and an empty playlist named "New Releases"
+func (s *Server) Seed() {
+	now := time.Now()
+	for _, name := range []string{"Artist One", "Artist Two"} {
+		a := s.FollowArtist(models.Artist{Name: name})
+		s.AddAlbum(a, models.SimplifiedAlbumObject{Name: name + " Album", AlbumType: "album", ReleaseDate: day(now.AddDate(0, 0, -7))},
+			models.Track{Name: name + " Song"}, models.Track{Name: name + " Ballad"}, models.Track{Name: name + " Outro", Explicit: true})
+		s.AddAlbum(a, models.SimplifiedAlbumObject{Name: name + " Old Single", AlbumType: "single", ReleaseDate: day(now.AddDate(-1, 0, 0))},
+			models.Track{Name: name + " Old Single"})
+		s.AddAlbum(a, models.SimplifiedAlbumObject{Name: name + " Single", AlbumType: "single", ReleaseDate: day(now.AddDate(0, 0, -1))},
+			models.Track{Name: name + " Single"})
+	}
+	s.AddPlaylist("New Releases")
+}
+
+func day(t time.Time) models.ReleaseDate {
+	d, _ := models.ParseReleaseDate(t.Format(models.DateLayout))
+	return d
+}
+
+/////////////////////////// ********* HANDLERS ********* ///////////////////////////////
+
+// authorize redirects right away to the redirect URI with the code, as if the user accepted
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.challenge = ""
+	if q.Get("code_challenge_method") == "S256" {
+		s.challenge = q.Get("code_challenge")
+	}
+	s.mu.Unlock()
+
+	v := redirect.Query()
+	v.Set("code", Code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// tokenEndpoint hands out the access token for the code of authorize, RefreshToken or the client credentials.
+// The code verifier is checked when authorize was given a PKCE code challenge, any other refresh token
+// is refused as if it was revoked.
+func (s *Server) tokenEndpoint(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		accountsError(w, "invalid_request", err.Error())
+		return
+	}
+	switch grant := r.PostForm.Get("grant_type"); grant {
+	case "authorization_code":
+		if r.PostForm.Get("code") != Code {
+			accountsError(w, "invalid_grant", "Invalid authorization code")
+			return
+		}
+		s.mu.Lock()
+		challenge := s.challenge
+		s.mu.Unlock()
+		if challenge != "" && spotify.CodeChallenge(r.PostForm.Get("code_verifier")) != challenge {
+			accountsError(w, "invalid_grant", "code_verifier was incorrect")
+			return
+		}
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") != RefreshToken {
+			accountsError(w, "invalid_grant", "Invalid refresh token")
+			return
+		}
+	case "client_credentials":
+	default:
+		accountsError(w, "unsupported_grant_type", "grant_type must be authorization_code, refresh_token or client_credentials")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  s.Token(),
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"refresh_token": RefreshToken,
+		"scope":         r.PostForm.Get("scope"),
+	})
+}
+
+// api checks the rate limit and the access token of the API requests,
+// and gives the GET responses an ETag and a Cache-Control max-age
+func (s *Server) api(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		limited := s.rateLimited > 0
+		if limited {
+			s.rateLimited--
+		}
+		failed, status := s.fail(r.Method)
+		retryAfter, token, maxAge := s.retryAfter, s.token, s.maxAge
+		s.mu.Unlock()
+
+		if failed {
+			injectFailure(w, status)
+			return
+		}
+		if limited {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			apiError(w, http.StatusTooManyRequests, "API rate limit exceeded")
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			apiError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+		if r.Method != "GET" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		if rec.Code == http.StatusOK {
+			sum := sha256.Sum256(rec.Body.Bytes())
+			etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	})
+}
+
+func (s *Server) getMe(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.user)
+}
+
+// getFollowing returns the followed artists, paged with the ID of the last artist as cursor
+func (s *Server) getFollowing(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("type") != "artist" {
+		apiError(w, http.StatusBadRequest, "type must be artist")
+		return
+	}
+	limit, ok := limitParam(w, q, 20, 50)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := 0
+	if after := q.Get("after"); after != "" {
+		for i, a := range s.followed {
+			if a.ID == after {
+				start = i + 1
+			}
+		}
+	}
+	end := start + limit
+	if end > len(s.followed) {
+		end = len(s.followed)
+	}
+	items := s.followed[start:end]
+
+	page := struct {
+		models.CursorBasedObj
+		Items []models.Artist `json:"items"`
+	}{Items: items}
+	page.Link = s.requestURL(r, q)
+	page.Limit = limit
+	page.Total = len(s.followed)
+	if end < len(s.followed) {
+		last := items[len(items)-1].ID
+		page.Cursor.After = last
+		next := cloneValues(q)
+		next.Set("after", last)
+		page.Next = s.requestURL(r, next)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"artists": page})
+}
+
+func (s *Server) getPlaylists(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]models.SimplePlaylist, 0, len(s.playlists))
+	for _, p := range s.playlists {
+		items = append(items, p.SimplePlaylist)
+	}
+	writePage(s, w, r, items, 20, 50)
+}
+
+func (s *Server) createPlaylist(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		apiError(w, http.StatusBadRequest, "Missing playlist name")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.PathValue("id") != s.user.ID {
+		apiError(w, http.StatusForbidden, "You cannot create a playlist for another user")
+		return
+	}
+	p := s.newPlaylist(body.Name, body.Description, body.Public)
+	writeJSON(w, http.StatusCreated, p.SimplePlaylist)
+}
+
+// getArtistAlbums returns the albums of the artist, 404 Not Found for an artist neither followed nor with albums
+func (s *Server) getArtistAlbums(w http.ResponseWriter, r *http.Request) {
+	groups := map[string]bool{}
+	if include := r.URL.Query().Get("include_groups"); include != "" {
+		for _, g := range strings.Split(include, ",") {
+			groups[g] = true
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	albums, ok := s.albums[r.PathValue("id")]
+	if !ok && !s.follows(r.PathValue("id")) {
+		apiError(w, http.StatusNotFound, "non existing id")
+		return
+	}
+	var items []*models.SimplifiedAlbumObject
+	for _, a := range albums {
+		if len(groups) == 0 || groups[a.AlbumGroup] {
+			items = append(items, a)
+		}
+	}
+	writePage(s, w, r, items, 20, 50)
+}
+
+// follows reports whether the user follows the artist. s.mu must be held.
+func (s *Server) follows(id string) bool {
+	for _, a := range s.followed {
+		if a.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) getAlbumTracks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tracks, ok := s.tracks[r.PathValue("id")]
+	if !ok {
+		apiError(w, http.StatusNotFound, "Non existing id")
+		return
+	}
+	writePage(s, w, r, tracks, 20, 50)
+}
+
+// getTracks returns the full tracks, null for the unknown IDs
+func (s *Server) getTracks(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) > 50 {
+		apiError(w, http.StatusBadRequest, "Too many ids requested")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tracks := make([]*models.Track, len(ids))
+	for i, id := range ids {
+		tracks[i] = s.tracksByURI["spotify:track:"+id]
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tracks": tracks})
+}
+
+func (s *Server) getPlaylist(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.playlist(r.PathValue("id"))
+	if p == nil {
+		apiError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, p.SimplePlaylist)
+}
+
+func (s *Server) getPlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.playlist(r.PathValue("id"))
+	if p == nil {
+		apiError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	writePage(s, w, r, p.items, 100, 100)
+}
+
+func (s *Server) addPlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		URIs []string `json:"uris"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiError(w, http.StatusBadRequest, "Error parsing JSON.")
+		return
+	}
+	if len(body.URIs) > 100 {
+		apiError(w, http.StatusBadRequest, "You can add a maximum of 100 tracks per request.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.playlist(r.PathValue("id"))
+	if p == nil {
+		apiError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	p.add(body.URIs, s.track, time.Now())
+	writeJSON(w, http.StatusCreated, map[string]string{"snapshot_id": p.SnapshotID})
+}
+
+// removePlaylistTracks removes the tracks at their positions, or every occurrence of the tracks given without
+// positions. Unlike Spotify, a snapshot ID other than the current one is refused instead of resolved.
+func (s *Server) removePlaylistTracks(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Tracks     []models.TrackPositions `json:"tracks"`
+		SnapshotID string                  `json:"snapshot_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiError(w, http.StatusBadRequest, "Error parsing JSON.")
+		return
+	}
+	if len(body.Tracks) > 100 {
+		apiError(w, http.StatusBadRequest, "You can remove a maximum of 100 tracks per request.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.playlist(r.PathValue("id"))
+	if p == nil {
+		apiError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	if body.SnapshotID != "" && body.SnapshotID != p.SnapshotID {
+		apiError(w, http.StatusBadRequest, "Invalid snapshot id")
+		return
+	}
+	removedURIs := map[string]bool{}
+	removedPositions := map[int]bool{}
+	for _, t := range body.Tracks {
+		if len(t.Positions) == 0 {
+			removedURIs[t.URI] = true
+			continue
+		}
+		for _, pos := range t.Positions {
+			if pos < 0 || pos >= len(p.items) || p.items[pos].Track.URI != t.URI {
+				apiError(w, http.StatusBadRequest, "Could not remove tracks, please check parameters.")
+				return
+			}
+			removedPositions[pos] = true
+		}
+	}
+	kept := p.items[:0]
+	for i, item := range p.items {
+		if !removedURIs[item.Track.URI] && !removedPositions[i] {
+			kept = append(kept, item)
+		}
+	}
+	p.items = kept
+	p.SnapshotID = s.newID()
+	writeJSON(w, http.StatusOK, map[string]string{"snapshot_id": p.SnapshotID})
+}
+
+/////////////////////////// ********* HELPERS ********* ///////////////////////////////
+
+// newPlaylist creates an empty playlist owned by the user, s.mu must be held
+func (s *Server) newPlaylist(name, description string, public bool) *playlist {
+	p := &playlist{}
+	p.ID = s.newID()
+	p.URI = "spotify:playlist:" + p.ID
+	p.Name = name
+	p.Description = description
+	p.Public = public
+	p.Owner = s.user.User
+	p.SnapshotID = s.newID()
+	s.playlists = append(s.playlists, p)
+	return p
+}
+
+// playlist returns the playlist with the ID, nil if there is none, s.mu must be held
+func (s *Server) playlist(id string) *playlist {
+	for _, p := range s.playlists {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// track returns the full track with the URI, a bare one for the tracks not in the catalog
+func (s *Server) track(uri string) *models.Track {
+	if t, ok := s.tracksByURI[uri]; ok {
+		return t
+	}
+	return &models.Track{URI: uri, ID: strings.TrimPrefix(uri, "spotify:track:"), Type: "track"}
+}
+
+// add appends the tracks to the playlist as added at the time
+func (p *playlist) add(uris []string, track func(string) *models.Track, at time.Time) {
+	for _, uri := range uris {
+		p.items = append(p.items, models.PlaylistTrack{
+			AddedAt: at.UTC().Format(models.TimestampLayout),
+			Track:   track(uri),
+		})
+	}
+	// Any new value will do, the snapshot only has to change
+	p.SnapshotID = strconv.FormatInt(at.UnixNano(), 10)
+}
+
+// requestURL returns the absolute URL of the request with the query
+func (s *Server) requestURL(r *http.Request, q url.Values) string {
+	return s.URL() + strings.TrimPrefix(r.URL.Path, "/") + "?" + q.Encode()
+}
+
+// writePage writes the offset page of the items requested by the limit and offset parameters
+func writePage[T any](s *Server, w http.ResponseWriter, r *http.Request, items []T, defaultLimit, maxLimit int) {
+	q := r.URL.Query()
+	limit, ok := limitParam(w, q, defaultLimit, maxLimit)
+	if !ok {
+		return
+	}
+	offset := 0
+	if o := q.Get("offset"); o != "" {
+		var err error
+		if offset, err = strconv.Atoi(o); err != nil || offset < 0 {
+			apiError(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+	}
+
+	start, end := offset, offset+limit
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+	page := struct {
+		models.Paging
+		Items []T `json:"items"`
+	}{Items: items[start:end]}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	page.Link = s.requestURL(r, q)
+	page.Limit = limit
+	page.Offset = offset
+	page.Total = len(items)
+	if end < len(items) {
+		next := cloneValues(q)
+		next.Set("offset", strconv.Itoa(end))
+		next.Set("limit", strconv.Itoa(limit))
+		page.Next = s.requestURL(r, next)
+	}
+	if offset > 0 {
+		previous := cloneValues(q)
+		previous.Set("offset", strconv.Itoa(max(offset-limit, 0)))
+		previous.Set("limit", strconv.Itoa(limit))
+		page.Previous = s.requestURL(r, previous)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// limitParam reads the limit parameter, answering 400 Bad Request when it is invalid
+func limitParam(w http.ResponseWriter, q url.Values, defaultLimit, maxLimit int) (int, bool) {
+	l := q.Get("limit")
+	if l == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(l)
+	if err != nil || limit < 1 || limit > maxLimit {
+		apiError(w, http.StatusBadRequest, "Invalid limit")
+		return 0, false
+	}
+	return limit, true
+}
+
+func cloneValues(v url.Values) url.Values {
+	c := url.Values{}
+	for k, values := range v {
+		c[k] = append([]string(nil), values...)
+	}
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// fail reports whether the request with the method must fail, and with which status. s.mu must be held.
+func (s *Server) fail(method string) (bool, int) {
+	f := s.failing
+	if f == nil || (f.method != "" && f.method != method) {
+		return false, 0
+	}
+	if f.after > 0 {
+		f.after--
+		return false, 0
+	}
+	f.n--
+	if f.n <= 0 {
+		s.failing = nil
+	}
+	return true, f.status
+}
+
+// injectFailure answers with the status, or breaks the connection when it is 0
+func injectFailure(w http.ResponseWriter, status int) {
+	if status == 0 {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		// A malformed answer, unlike a connection closed before answering, is never retried by the transport
+		buf.WriteString("HTTP/1.1 broken\r\n\r\n")
+		buf.Flush()
+		conn.Close()
+		return
+	}
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	apiError(w, status, "injected failure")
+}
+
+// apiError writes an error the way the Web API does
+func apiError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"status": status, "message": message},
+	})
+}
+
+// accountsError writes an error the way the Accounts service does
+func accountsError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
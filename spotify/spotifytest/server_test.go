@@ -0,0 +1,258 @@
+package spotifytest_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+)
+
+// request returns an API request to the path, relative to the server URL, carrying the access token
+func request(s *spotifytest.Server, method, path, body string) *http.Request {
+	req, err := http.NewRequest(method, s.URL()+path, strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Token())
+	return req
+}
+
+// send sends the request and returns the response with its body read
+func send(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestServerToken(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"token", "Bearer " + s.Token(), http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := request(s, "GET", "me", "")
+		req.Header.Set("Authorization", tt.authorization)
+		if resp, _ := send(t, req); resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+	if s.Requests() != len(tests) {
+		t.Errorf("Requests() = %d, want %d", s.Requests(), len(tests))
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	s.RateLimit(2, 3*time.Second)
+
+	for i, want := range []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK} {
+		resp, _ := send(t, request(s, "GET", "me", ""))
+		if resp.StatusCode != want {
+			t.Errorf("request %d: status %d, want %d", i, resp.StatusCode, want)
+		}
+		if want == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "3" {
+			t.Errorf("request %d: Retry-After %q, want 3", i, resp.Header.Get("Retry-After"))
+		}
+	}
+}
+
+func TestServerFailNext(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	id := s.AddPlaylist("Playlist")
+	add := `{"uris":["spotify:track:a"]}`
+
+	// Only the requests with the method fail
+	s.FailNext("POST", http.StatusInternalServerError, 1)
+	steps := []struct {
+		req  *http.Request
+		want int
+	}{
+		{request(s, "GET", "me", ""), http.StatusOK},
+		{request(s, "POST", "playlists/"+id+"/tracks", add), http.StatusInternalServerError},
+		{request(s, "POST", "playlists/"+id+"/tracks", add), http.StatusCreated},
+	}
+	for i, step := range steps {
+		if resp, _ := send(t, step.req); resp.StatusCode != step.want {
+			t.Errorf("request %d: status %d, want %d", i, resp.StatusCode, step.want)
+		}
+	}
+	// The failed request was not carried out
+	if got := s.PlaylistTracks(id); len(got) != 1 {
+		t.Errorf("playlist tracks = %v, want one", got)
+	}
+
+	s.FailAfter("", 1, http.StatusServiceUnavailable, 1)
+	for i, want := range []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusOK} {
+		resp, body := send(t, request(s, "GET", "me", ""))
+		if resp.StatusCode != want {
+			t.Errorf("request %d after one: status %d, want %d", i, resp.StatusCode, want)
+		}
+		if want == http.StatusServiceUnavailable {
+			if resp.Header.Get("Retry-After") != "1" {
+				t.Errorf("Retry-After %q, want 1", resp.Header.Get("Retry-After"))
+			}
+			var e struct {
+				Error struct {
+					Status int `json:"status"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(body, &e); err != nil || e.Error.Status != want {
+				t.Errorf("body %s, want an API error", body)
+			}
+		}
+	}
+
+	// A status of 0 breaks the connection
+	s.FailNext("", 0, 1)
+	if resp, err := http.DefaultClient.Do(request(s, "GET", "me", "")); err == nil {
+		resp.Body.Close()
+		t.Errorf("broken connection answered with status %d", resp.StatusCode)
+	}
+	if resp, _ := send(t, request(s, "GET", "me", "")); resp.StatusCode != http.StatusOK {
+		t.Errorf("after the broken connection: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestServerETag(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	s.CacheMaxAge(time.Minute)
+
+	resp, _ := send(t, request(s, "GET", "me", ""))
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "private, max-age=60" {
+		t.Errorf("Cache-Control %q, want private, max-age=60", got)
+	}
+
+	req := request(s, "GET", "me", "")
+	req.Header.Set("If-None-Match", etag)
+	if resp, body := send(t, req); resp.StatusCode != http.StatusNotModified || len(body) != 0 {
+		t.Errorf("status %d with %d bytes, want 304 and no body", resp.StatusCode, len(body))
+	}
+
+	u := models.PrivateUser{Country: "FR"}
+	u.ID = "other"
+	s.SetUser(u)
+	req = request(s, "GET", "me", "")
+	req.Header.Set("If-None-Match", etag)
+	resp, _ = send(t, req)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Errorf("status %d with ETag %s after a change, want 200 and a new ETag", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
+
+func TestServerArtistAlbums(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	artist := s.FollowArtist(models.Artist{Name: "Artist"})
+	for _, name := range []string{"One", "Two", "Three"} {
+		s.AddAlbum(artist, models.SimplifiedAlbumObject{Name: name})
+	}
+	followed := s.FollowArtist(models.Artist{Name: "No albums"})
+
+	var page struct {
+		models.Paging
+		Items []models.SimplifiedAlbumObject `json:"items"`
+	}
+	path := "artists/" + artist.ID + "/albums?limit=2"
+	var names []string
+	for path != "" {
+		resp, body := send(t, request(s, "GET", path, ""))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		page.Next, page.Items = "", nil
+		if err := json.Unmarshal(body, &page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 3 {
+			t.Errorf("GET %s: total %d, want 3", path, page.Total)
+		}
+		for _, a := range page.Items {
+			names = append(names, a.Name)
+		}
+		path = strings.TrimPrefix(page.Next, s.URL())
+	}
+	if want := []string{"One", "Two", "Three"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("albums %v, want %v", names, want)
+	}
+	if page.Offset != 2 || page.Previous == "" {
+		t.Errorf("last page at offset %d with previous %q, want offset 2 and a previous page", page.Offset, page.Previous)
+	}
+
+	tests := []struct {
+		id   string
+		want int
+	}{
+		{followed.ID, http.StatusOK},
+		{s.NewID(), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if resp, _ := send(t, request(s, "GET", "artists/"+tt.id+"/albums", "")); resp.StatusCode != tt.want {
+			t.Errorf("albums of %s: status %d, want %d", tt.id, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestServerRemoveStaleSnapshot(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	id := s.AddPlaylist("Playlist", "spotify:track:a", "spotify:track:b")
+
+	_, body := send(t, request(s, "GET", "playlists/"+id, ""))
+	var p models.SimplePlaylist
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+	send(t, request(s, "POST", "playlists/"+id+"/tracks", `{"uris":["spotify:track:c"]}`))
+
+	remove := func(snapshot string) int {
+		resp, _ := send(t, request(s, "DELETE", "playlists/"+id+"/tracks",
+			`{"tracks":[{"uri":"spotify:track:a","positions":[0]}],"snapshot_id":"`+snapshot+`"}`))
+		return resp.StatusCode
+	}
+	// The playlist changed since the snapshot
+	if status := remove(p.SnapshotID); status != http.StatusBadRequest {
+		t.Errorf("remove with a stale snapshot: status %d, want 400", status)
+	}
+	if got := s.PlaylistTracks(id); len(got) != 3 {
+		t.Errorf("playlist tracks %v after a refused removal, want the three", got)
+	}
+
+	_, body = send(t, request(s, "GET", "playlists/"+id, ""))
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if status := remove(p.SnapshotID); status != http.StatusOK {
+		t.Errorf("remove with the current snapshot: status %d, want 200", status)
+	}
+	if got, want := strings.Join(s.PlaylistTracks(id), ","), "spotify:track:b,spotify:track:c"; got != want {
+		t.Errorf("playlist tracks %s, want %s", got, want)
+	}
+}
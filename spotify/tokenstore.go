@@ -0,0 +1,114 @@
+package spotify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+	"golang.org/x/oauth2"
+)
+
+// TokenStore : Keeps the token of a user between runs, so the user only logs in once
+type TokenStore interface {
+	// Load returns the saved token, nil if there is none
+	Load() (*oauth2.Token, error)
+	Save(token *oauth2.Token) error
+}
+
+// FileTokenStore : TokenStore keeping the token in a JSON file only readable by its owner
+type FileTokenStore struct {
+	Path string
+}
+
+// NewFileTokenStore : Creates a store keeping the token in the file at path
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{Path: path}
+}
+
+// Load : Reads the token, nil if the file does not exist yet
+func (s *FileTokenStore) Load() (*oauth2.Token, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("%s: %v", s.Path, err)
+	}
+	return &token, nil
+}
+
+// Save : Writes the token, replacing the file at once so a crash never leaves half a token
+func (s *FileTokenStore) Save(token *oauth2.Token) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// The refresh token gives access to the account for months
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// storingTokenSource saves the tokens of its source every time they are refreshed
+type storingTokenSource struct {
+	source oauth2.TokenSource
+	store  TokenStore
+
+	mu sync.Mutex
+	// last is the token saved last
+	last *oauth2.Token
+}
+
+func (s *storingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken != s.last.AccessToken || !token.Expiry.Equal(s.last.Expiry) {
+		if err := s.store.Save(token); err != nil {
+			return nil, fmt.Errorf("spotify: couldn't save the refreshed token: %v", err)
+		}
+		s.last = token
+	}
+	return token, nil
+}
+
+// NewStoredClient : Creates a Client using the token, which is refreshed with its refresh token
+// when it expires. The token is saved to the store right away and every time it is refreshed.
+func (a Authenticator) NewStoredClient(token *oauth2.Token, store TokenStore, middlewares ...models.Middleware) (models.Client, error) {
+	if err := store.Save(token); err != nil {
+		return models.Client{}, err
+	}
+	source := &storingTokenSource{
+		source: a.config.TokenSource(a.context, token),
+		store:  store,
+		last:   token,
+	}
+	return a.newClient(oauth2.ReuseTokenSource(token, source), middlewares), nil
+}
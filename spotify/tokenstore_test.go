@@ -0,0 +1,93 @@
+package spotify_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify"
+	"github.com/Kozehh/SpotifyFunc/spotify/spotifytest"
+	"golang.org/x/oauth2"
+)
+
+func TestFileTokenStore(t *testing.T) {
+	dir := t.TempDir()
+	store := spotify.NewFileTokenStore(filepath.Join(dir, "token.json"))
+
+	tok, err := store.Load()
+	if tok != nil || err != nil {
+		t.Fatalf("Load() of a missing file = %v, %v, want nil, nil", tok, err)
+	}
+
+	for _, access := range []string{"first", "second"} {
+		saved := &oauth2.Token{AccessToken: access, TokenType: "Bearer", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour).Round(0)}
+		if err := store.Save(saved); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(store.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("token file mode %v, want 0600", perm)
+		}
+		tok, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.AccessToken != saved.AccessToken || tok.RefreshToken != saved.RefreshToken || !tok.Expiry.Equal(saved.Expiry) {
+			t.Errorf("Load() = %+v, want %+v", tok, saved)
+		}
+	}
+	// The temporary files are renamed to the token file
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files in the directory, want only the token file", len(entries))
+	}
+}
+
+// countingStore keeps the tokens saved in memory
+type countingStore struct {
+	saved []*oauth2.Token
+}
+
+func (s *countingStore) Load() (*oauth2.Token, error) {
+	if len(s.saved) == 0 {
+		return nil, nil
+	}
+	return s.saved[len(s.saved)-1], nil
+}
+
+func (s *countingStore) Save(token *oauth2.Token) error {
+	s.saved = append(s.saved, token)
+	return nil
+}
+
+func TestStoredClientSavesRefreshedToken(t *testing.T) {
+	s := spotifytest.NewServer()
+	defer s.Close()
+	store := &countingStore{}
+
+	expired := &oauth2.Token{AccessToken: "expired", TokenType: "Bearer", RefreshToken: spotifytest.RefreshToken, Expiry: time.Now().Add(-time.Hour)}
+	c, err := s.Authenticator(redirectURI).NewStoredClient(expired, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 {
+		t.Fatalf("%d tokens saved by NewStoredClient, want 1", len(store.saved))
+	}
+
+	// The expired token would be refused, the refreshed one is sent
+	for i := 0; i < 2; i++ {
+		if _, err := c.CurrentUser(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.saved) != 2 {
+		t.Fatalf("%d tokens saved, want the first and the refreshed one only", len(store.saved))
+	}
+	refreshed, _ := store.Load()
+	if refreshed.AccessToken != s.Token() || !refreshed.Expiry.After(time.Now()) {
+		t.Errorf("saved %q expiring at %s, want the refreshed token", refreshed.AccessToken, refreshed.Expiry)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SeenStore : Durable record of the albums already processed by the release pipeline.
+// Album IDs are kept per Spotify user so several accounts can share the same file.
+type SeenStore struct {
+	path string
+	mu   sync.Mutex
+
+	// Users maps a Spotify user ID to the albums already handled for that user
+	Users map[string]*UserReleases `json:"users"`
+}
+
+// UserReleases : Releases already handled for a single user
+type UserReleases struct {
+	// Albums maps an album ID to the time it was added to the playlist
+	Albums map[string]time.Time `json:"albums"`
+	// LastRun is the time of the last successful run for the user
+	LastRun time.Time `json:"last_run"`
+}
+
+// LoadSeenStore : Reads the store saved at path.
+// A missing file is not an error, it returns an empty store that will be created on Save.
+func LoadSeenStore(path string) (*SeenStore, error) {
+	s := &SeenStore{
+		path:  path,
+		Users: map[string]*UserReleases{},
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.Users == nil {
+		s.Users = map[string]*UserReleases{}
+	}
+	return s, nil
+}
+
+// Seen : Reports whether the album was already processed for the user
+func (s *SeenStore) Seen(userID, albumID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[userID]
+	if !ok {
+		return false
+	}
+	_, ok = u.Albums[albumID]
+	return ok
+}
+
+// MarkSeen : Records the albums as processed for the user
+func (s *SeenStore) MarkSeen(userID string, albumIDs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.user(userID)
+	now := time.Now()
+	for _, id := range albumIDs {
+		u.Albums[id] = now
+	}
+}
+
+// LastRun : Returns the time of the last successful run for the user, zero if none
+func (s *SeenStore) LastRun(userID string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.Users[userID]; ok {
+		return u.LastRun
+	}
+	return time.Time{}
+}
+
+// SetLastRun : Records the time of a successful run for the user
+func (s *SeenStore) SetLastRun(userID string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user(userID).LastRun = t
+}
+
+// Save : Writes the store back to its file.
+// The content is written to a temporary file first so a crash never leaves a truncated store.
+func (s *SeenStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// user returns the releases of a user, creating them if needed. s.mu must be held.
+func (s *SeenStore) user(userID string) *UserReleases {
+	u, ok := s.Users[userID]
+	if !ok {
+		u = &UserReleases{}
+		s.Users[userID] = u
+	}
+	if u.Albums == nil {
+		u.Albums = map[string]time.Time{}
+	}
+	return u
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+// Kinds of release window
+const (
+	WindowDays         = "days"
+	WindowSinceLastRun = "last-run"
+	WindowSinceDate    = "since"
+
+	// fallbackDays is the window used on the first "since last run" run
+	fallbackDays = 30
+)
+
+// ReleaseWindow : Period of time in which a release is considered new
+type ReleaseWindow struct {
+	Kind string
+	// Number of days before now, for WindowDays
+	Days int
+	// Start of the window, for WindowSinceDate
+	Since time.Time
+}
+
+// ParseReleaseWindow : Parses a window given as
+// "30d" for the last 30 days, "last-run" for everything since the previous run
+// or a date like "2020-06-01" for everything released since that day
+func ParseReleaseWindow(s string) (ReleaseWindow, error) {
+	s = strings.TrimSpace(s)
+	if s == WindowSinceLastRun {
+		return ReleaseWindow{Kind: WindowSinceLastRun}, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return ReleaseWindow{}, fmt.Errorf("invalid release window %q: expected a number of days like \"30d\"", s)
+		}
+		if days <= 0 {
+			return ReleaseWindow{}, fmt.Errorf("release window must be at least one day, got %q", s)
+		}
+		return ReleaseWindow{Kind: WindowDays, Days: days}, nil
+	}
+	since, err := time.Parse(models.DateLayout, s)
+	if err != nil {
+		return ReleaseWindow{}, fmt.Errorf("invalid release window %q: expected a number of days like \"30d\", %q or a YYYY-MM-DD date", s, WindowSinceLastRun)
+	}
+	return ReleaseWindow{Kind: WindowSinceDate, Since: since}, nil
+}
+
+// Start : Returns the beginning of the window.
+// lastRun is only used by WindowSinceLastRun; with no previous run it falls back to fallbackDays.
+func (w ReleaseWindow) Start(now, lastRun time.Time) time.Time {
+	switch w.Kind {
+	case WindowSinceDate:
+		return w.Since
+	case WindowSinceLastRun:
+		if !lastRun.IsZero() {
+			// Release dates have no time of day, start from the day of the last run
+			// so an album released that day after the run is not missed
+			y, m, d := lastRun.UTC().Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		}
+		return now.AddDate(0, 0, -fallbackDays)
+	default:
+		return now.AddDate(0, 0, -w.Days)
+	}
+}
+
+func (w ReleaseWindow) String() string {
+	switch w.Kind {
+	case WindowSinceDate:
+		return w.Since.Format(models.DateLayout)
+	case WindowSinceLastRun:
+		return WindowSinceLastRun
+	default:
+		return strconv.Itoa(w.Days) + "d"
+	}
+}
+
+// IsNewRelease : Check if the album was released in the window starting at since.
+// Albums with a year or month precision are dated to the last day of that year or month,
+// see models.ReleaseDate.LastDay.
+func IsNewRelease(album *models.SimplifiedAlbumObject, since, now time.Time) bool {
+	return album.ReleaseDate.Within(since, now)
+}
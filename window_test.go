@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kozehh/SpotifyFunc/spotify/models"
+)
+
+func TestParseReleaseWindow(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ReleaseWindow
+		wantErr bool
+	}{
+		{in: "30d", want: ReleaseWindow{Kind: WindowDays, Days: 30}},
+		{in: " 7d ", want: ReleaseWindow{Kind: WindowDays, Days: 7}},
+		{in: "last-run", want: ReleaseWindow{Kind: WindowSinceLastRun}},
+		{in: "2020-06-01", want: ReleaseWindow{Kind: WindowSinceDate, Since: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}},
+		// A bare number could be a year as well as a number of days
+		{in: "2020", wantErr: true},
+		{in: "30", wantErr: true},
+		{in: "0d", wantErr: true},
+		{in: "-3d", wantErr: true},
+		{in: "d", wantErr: true},
+		{in: "2020-06", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReleaseWindow(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseReleaseWindow(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (got.Kind != tt.want.Kind || got.Days != tt.want.Days || !got.Since.Equal(tt.want.Since)) {
+			t.Errorf("ParseReleaseWindow(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReleaseWindowStart(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	lastRun := time.Date(2020, 6, 10, 18, 30, 0, 0, time.UTC)
+	tests := []struct {
+		window  string
+		lastRun time.Time
+		want    time.Time
+	}{
+		{"30d", lastRun, now.AddDate(0, 0, -30)},
+		{"2020-01-01", lastRun, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"last-run", lastRun, time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)},
+		{"last-run", time.Time{}, now.AddDate(0, 0, -fallbackDays)},
+	}
+	for _, tt := range tests {
+		w, err := ParseReleaseWindow(tt.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Start(now, tt.lastRun); !got.Equal(tt.want) {
+			t.Errorf("%s with last run %v: Start = %v, want %v", tt.window, tt.lastRun, got, tt.want)
+		}
+	}
+}
+
+func TestIsNewRelease(t *testing.T) {
+	since := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2020, 7, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		date      string
+		precision string
+		want      bool
+	}{
+		{"2020-06-01", models.PrecisionDay, true},
+		{"2020-07-10", models.PrecisionDay, true},
+		{"2020-05-31", models.PrecisionDay, false},
+		{"2020-07-11", models.PrecisionDay, false},
+		// Dated to the last day of the month
+		{"2020-05", models.PrecisionMonth, false},
+		{"2020-06", models.PrecisionMonth, true},
+		{"2020-07", models.PrecisionMonth, false},
+		// Dated to December 31, even though the window touches 2020
+		{"2020", models.PrecisionYear, false},
+		{"2019", models.PrecisionYear, false},
+		{"not a date", "", false},
+	}
+	for _, tt := range tests {
+		d, _ := models.ParseReleaseDatePrecision(tt.date, tt.precision)
+		album := &models.SimplifiedAlbumObject{ReleaseDate: d}
+		if got := IsNewRelease(album, since, now); got != tt.want {
+			t.Errorf("IsNewRelease(%q) = %v, want %v", tt.date, got, tt.want)
+		}
+	}
+}